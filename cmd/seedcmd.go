@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/seeder"
 	"github.com/spf13/cobra"
 	"gorm.io/driver/mysql"
@@ -33,6 +34,29 @@ var seedingCommand = &cobra.Command{
     },
 }
 
+var promoteOwnerCommand = &cobra.Command{
+    Use:   "promote-owner [username]",
+    Short: "Make a user an owner, the user is created with OWNER_PASSWORD when missing",
+    Args:  cobra.ExactArgs(1),
+    Run: func(cmd *cobra.Command, args []string) {
+
+		DBURL := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+
+		DB, err := gorm.Open(mysql.Open(DBURL), &gorm.Config{})
+        if err != nil {
+            fmt.Println("Failed to connect to the database")
+            return
+        }
+
+        user, err := models.PromoteOwner(DB, args[0], os.Getenv("OWNER_PASSWORD"))
+        if err != nil {
+            fmt.Println("Promote owner error:", err)
+            return
+        }
+        fmt.Println("User", user.Username, "is now an owner")
+    },
+}
+
 func Execute() {
     if err := rootCmd.Execute(); err != nil {
         if err != nil {
@@ -44,4 +68,5 @@ func Execute() {
 
 func init() {
     rootCmd.AddCommand(seedingCommand)
+    rootCmd.AddCommand(promoteOwnerCommand)
 }
\ No newline at end of file
@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/middlewares"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+// currentActor is who the audit log records for this request
+func currentActor(context *gin.Context) models.Actor {
+
+	if value, exists := context.Get(middlewares.APIKeyContextKey); exists {
+		return models.Actor{APIKeyId: value.(*models.APIKey).ID}
+	}
+
+	user_id, _ := token.ExtractTokenID(context)
+
+	return models.Actor{UserId: user_id}
+}
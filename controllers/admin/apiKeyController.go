@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+)
+
+func GetAllAPIKeys(context *gin.Context) {
+
+	data, err := models.GetAllAPIKeys()
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetAPIKey(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+        return
+    }
+
+	model, err := models.GetAPIKey(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": model})
+}
+
+func CreateAPIKey(context *gin.Context) {
+
+	var input models.CreateAPIKey
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	plainKey, model, err := input.CreateAPIKey(currentActor(context))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "create success", "data": gin.H{"key": plainKey, "api_key": model}})
+}
+
+func RevokeAPIKey(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+        return
+    }
+
+	_, err = models.RevokeAPIKey(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
+}
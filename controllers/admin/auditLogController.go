@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+)
+
+func GetAllAuditLogs(context *gin.Context) {
+
+	data, err := models.GetAllAuditLogs(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
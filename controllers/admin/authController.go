@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
 )
@@ -29,8 +30,38 @@ func CurrentUser(context *gin.Context) {
 }
 
 type LoginInput struct {
-	Username string `json:"username" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceId   string `json:"device_id"`
+	DeviceName string `json:"device_name"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ResetPasswordInput struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorInput struct {
 	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 func Login(context *gin.Context) {
@@ -42,24 +73,81 @@ func Login(context *gin.Context) {
 		return
 	}
 
-	model := models.User{}
+	tokens, err := models.LoginCheck(models.LoginCredentials{
+		Username:   input.Username,
+		Password:   input.Password,
+		IP:         context.ClientIP(),
+		DeviceId:   input.DeviceId,
+		DeviceName: input.DeviceName,
+	})
 
-	model.Username = input.Username
-	model.Password = input.Password
+	if err != nil {
+		if err == models.ErrorLoginLocked {
+			context.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if err == models.ErrorUserInactive {
+			context.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": "username or password is incorrect."})
+		return
+	}
+
+	context.JSON(http.StatusOK, tokens)
+}
+
+func LoginTwoFactor(context *gin.Context) {
+
+	var input TwoFactorLoginInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	token, err := models.LoginCheck(model.Username, model.Password)
+	tokens, err := models.CompleteTwoFactorLogin(input.ChallengeToken, input.Code)
 
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "username or password is incorrect."})
+		if err == models.ErrorLoginLocked {
+			context.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if err == models.ErrorUserInactive {
+			context.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	context.JSON(http.StatusOK, gin.H{"token": token})
+	context.JSON(http.StatusOK, tokens)
 }
 
-func Logout(context *gin.Context) {
+func RefreshToken(context *gin.Context) {
 
-	tokenString := token.ExtractToken(context)
+	var input RefreshTokenInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := models.RefreshAuthTokens(input.RefreshToken)
+
+	if err != nil {
+		if err == models.ErrorInvalidRefreshToken || err == models.ErrorUserInactive {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, tokens)
+}
+
+func Logout(context *gin.Context) {
 
 	err := token.TokenValid(context)
 
@@ -69,7 +157,235 @@ func Logout(context *gin.Context) {
 		return
 	}
 
-	token.Logout(tokenString)
+	claims, err := token.ExtractTokenClaims(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	err = models.RevokeToken(claims.JTI, claims.UserID, claims.ExpiresAt)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if claims.DeviceID != "" {
+		err = models.RevokeUserDevice(claims.UserID, claims.DeviceID)
+
+		if err != nil && err != helper.ErrorRecordNotFound {
+			context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	context.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
-}
\ No newline at end of file
+}
+
+func LogoutAll(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = models.RevokeAllUserTokens(user_id)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
+}
+
+func GetDevices(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	devices, err := models.GetUserDevices(user_id)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": devices})
+}
+
+func RevokeDevice(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = models.RevokeUserDevice(user_id, context.Param("device_id"))
+
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+			context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
+}
+
+func ChangePassword(context *gin.Context) {
+
+	var input ChangePasswordInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = models.ChangeOwnPassword(user_id, input.CurrentPassword, input.NewPassword)
+
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+			context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+}
+
+func ResetPassword(context *gin.Context) {
+
+	var input ResetPasswordInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := models.ResetPassword(input.Token, input.NewPassword)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+}
+
+func SetupTwoFactor(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setup, err := models.SetupTwoFactor(user_id)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": setup})
+}
+
+func EnableTwoFactor(context *gin.Context) {
+
+	var input TwoFactorCodeInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := models.EnableTwoFactor(user_id, input.Code)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": gin.H{"recovery_codes": codes}})
+}
+
+func DisableTwoFactor(context *gin.Context) {
+
+	var input DisableTwoFactorInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = models.DisableTwoFactor(user_id, input.Password, input.Code)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+}
+
+func RegenerateRecoveryCodes(context *gin.Context) {
+
+	var input TwoFactorCodeInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := models.RegenerateRecoveryCodes(user_id, input.Code)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": gin.H{"recovery_codes": codes}})
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+)
+
+func GetPurchaseOrderGoodsReceivedNotes(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.GetPurchaseOrderGoodsReceivedNotes(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetGoodsReceivedNote(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GoodsReceivedNote ID"})
+        return
+    }
+
+	data, err := models.GetGoodsReceivedNote(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func VoidGoodsReceivedNote(context *gin.Context) {
+
+	var input models.VoidGoodsReceivedNote
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GoodsReceivedNote ID"})
+        return
+    }
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.VoidGoodsReceivedNote(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+// GetJWKS answers in the plain JWKS shape so other services can use it
+// with any JWT library
+func GetJWKS(context *gin.Context) {
+
+	keys, err := token.JWKS()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+func GetAllNotifications(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := models.GetAllNotifications(context, user_id)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func CountUnreadNotifications(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.CountUnreadNotifications(user_id)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": gin.H{"unread_count": count}})
+}
+
+func markNotification(context *gin.Context, read bool) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Notification ID"})
+        return
+    }
+
+	data, err := models.MarkNotification(user_id, id, read)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
+
+func ReadNotification(context *gin.Context) {
+	markNotification(context, true)
+}
+
+func UnreadNotification(context *gin.Context) {
+	markNotification(context, false)
+}
+
+func ReadAllNotifications(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.MarkAllNotificationsRead(user_id)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": gin.H{"count": count}})
+}
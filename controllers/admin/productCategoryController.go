@@ -58,7 +58,7 @@ func CreateProductCategory(context *gin.Context) {
         return
 	}
 
-	_, err := input.CreateProductCategory()
+	_, err := input.CreateProductCategory(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -89,7 +89,7 @@ func UpdateProductCategory(context *gin.Context) {
         return
 	}
 
-	_, err = input.UpdateProductCategory(id)
+	_, err = input.UpdateProductCategory(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -111,7 +111,7 @@ func DeleteProductCategory(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeleteProductCategory(id)
+	_, err = input.DeleteProductCategory(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -43,6 +43,48 @@ func GetProduct(context *gin.Context) {
 	context.JSON(http.StatusOK, gin.H{"message": "success", "data": model})
 }
 
+func GetProductStock(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Product ID"})
+        return
+    }
+
+	data, err := models.GetProductStock(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetStockMovements(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Product Variation ID"})
+        return
+    }
+
+	data, err := models.GetStockMovements(context, id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
 func CreateProduct(context *gin.Context) {
 
 	var input models.Product
@@ -60,7 +102,7 @@ func CreateProduct(context *gin.Context) {
         return
 	}
 
-	_, err := input.CreateProduct()
+	_, err := input.CreateProduct(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -84,7 +126,7 @@ func UpdateProduct(context *gin.Context) {
         return
     }
 
-	_, err = input.UpdateProduct(id)
+	_, err = input.UpdateProduct(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -106,7 +148,7 @@ func DeleteProduct(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeleteProduct(id)
+	_, err = input.DeleteProduct(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -129,7 +171,7 @@ func UploadImage(context *gin.Context) {
         return
 	}
 
-	_, err := input.UploadImage()
+	_, err := input.UploadImage(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -148,7 +190,7 @@ func DeleteImage(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeleteImage(id)
+	_, err = input.DeleteImage(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
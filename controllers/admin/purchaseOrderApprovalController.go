@@ -0,0 +1,198 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+func GetAllPurchaseOrderApprovalRules(context *gin.Context) {
+
+	data, err := models.GetAllPurchaseOrderApprovalRules(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetPurchaseOrderApprovalRule(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrderApprovalRule ID"})
+        return
+    }
+
+	model, err := models.GetPurchaseOrderApprovalRule(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": model})
+}
+
+func CreatePurchaseOrderApprovalRule(context *gin.Context) {
+
+	var input models.PurchaseOrderApprovalRule
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	_, err := input.CreatePurchaseOrderApprovalRule(currentActor(context))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "create success"})
+}
+
+func UpdatePurchaseOrderApprovalRule(context *gin.Context) {
+
+	var input models.PurchaseOrderApprovalRule
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrderApprovalRule ID"})
+        return
+    }
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	_, err = input.UpdatePurchaseOrderApprovalRule(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+}
+
+func DeletePurchaseOrderApprovalRule(context *gin.Context) {
+
+	var input models.PurchaseOrderApprovalRule
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrderApprovalRule ID"})
+        return
+    }
+
+	_, err = input.DeletePurchaseOrderApprovalRule(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
+}
+
+func GetPurchaseOrderApprovals(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.GetPurchaseOrderApprovals(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetPendingPurchaseOrderApprovals(context *gin.Context) {
+
+	user_id, err := token.ExtractTokenID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := models.GetPendingPurchaseOrderApprovals(context, user_id)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func DecidePurchaseOrderApproval(context *gin.Context) {
+
+	var input models.DecidePurchaseOrderApproval
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.DecidePurchaseOrderApproval(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -58,7 +59,7 @@ func CreatePurchaseOrder(context *gin.Context) {
         return
 	}
 
-	_, err := input.CreatePurchaseOrder()
+	_, err := input.CreatePurchaseOrder(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -82,7 +83,7 @@ func UpdatePurchaseOrder(context *gin.Context) {
         return
     }
 
-	_, err = input.UpdatePurchaseOrder(id)
+	_, err = input.UpdatePurchaseOrder(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -109,7 +110,14 @@ func ReceivePurchaseOrder(context *gin.Context) {
         return
     }
 
-	_, err = input.ReceivePurchaseOrder(id)
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.ReceivePurchaseOrder(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -119,7 +127,7 @@ func ReceivePurchaseOrder(context *gin.Context) {
 		return
 	}
 
-	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
 }
 
 func DeletePurchaseOrder(context *gin.Context) {
@@ -132,7 +140,7 @@ func DeletePurchaseOrder(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeletePurchaseOrder(id)
+	_, err = input.DeletePurchaseOrder(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -143,4 +151,177 @@ func DeletePurchaseOrder(context *gin.Context) {
 	}
 
 	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
-}
\ No newline at end of file
+}
+func ApprovePurchaseOrder(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.ApprovePurchaseOrder(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
+
+func SendPurchaseOrder(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.SendPurchaseOrder(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
+
+func CancelPurchaseOrder(context *gin.Context) {
+
+	var input models.CancelPurchaseOrder
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.CancelPurchaseOrder(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
+
+func ClosePurchaseOrder(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.ClosePurchaseOrder(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success", "data": data})
+}
+
+func GetPurchaseOrderPdf(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, fileName, err := models.GetPurchaseOrderPdf(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName))
+	context.Data(http.StatusOK, "application/pdf", data)
+}
+
+func EmailPurchaseOrder(context *gin.Context) {
+
+	var input models.EmailPurchaseOrder
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.EmailPurchaseOrder(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetPurchaseOrderEmails(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid PurchaseOrder ID"})
+        return
+    }
+
+	data, err := models.GetPurchaseOrderEmails(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
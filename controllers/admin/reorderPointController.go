@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+)
+
+func GetAllReorderPoints(context *gin.Context) {
+
+	data, err := models.GetAllReorderPoints(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func GetReorderPoint(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ReorderPoint ID"})
+        return
+    }
+
+	model, err := models.GetReorderPoint(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": model})
+}
+
+func CreateReorderPoint(context *gin.Context) {
+
+	var input models.ReorderPoint
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	_, err := input.CreateReorderPoint(currentActor(context))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "create success"})
+	
+}
+
+func UpdateReorderPoint(context *gin.Context) {
+
+	var input models.ReorderPoint
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ReorderPoint ID"})
+        return
+    }
+
+	_, err = input.UpdateReorderPoint(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "update success"})
+}
+
+func DeleteReorderPoint(context *gin.Context) {
+
+	var input models.ReorderPoint
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ReorderPoint ID"})
+        return
+    }
+	
+	_, err = input.DeleteReorderPoint(id, currentActor(context))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
+}
+
+func GetPurchaseOrderSuggestions(context *gin.Context) {
+
+	data, err := models.GetPurchaseOrderSuggestions(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func ConvertPurchaseOrderSuggestion(context *gin.Context) {
+
+	var input models.ConvertPurchaseOrderSuggestion
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+	}
+
+	if err := validator.New().Struct(input); err != nil {
+		errorResponse := helper.ProcessValidationErrors(err)
+
+        context.JSON(http.StatusBadRequest, gin.H{"error": errorResponse})
+        return
+	}
+
+	data, err := input.ConvertPurchaseOrderSuggestion(currentActor(context))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "create success", "data": gin.H{"id": data.ID, "order_no": data.OrderNo}})
+}
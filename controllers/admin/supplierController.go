@@ -56,7 +56,7 @@ func CreateSupplier(context *gin.Context) {
         return
 	}
 
-	_, err := input.CreateSupplier()
+	_, err := input.CreateSupplier(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -89,7 +89,7 @@ func UpdateSupplier(context *gin.Context) {
         return
 	}
 
-	_, err = input.UpdateSupplier(id)
+	_, err = input.UpdateSupplier(id, currentActor(context))
 
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
@@ -113,7 +113,7 @@ func DeleteSupplier(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeleteSupplier(id)
+	_, err = input.DeleteSupplier(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
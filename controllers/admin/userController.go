@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
 )
 
 func GetAllUsers(context *gin.Context) {
@@ -49,7 +50,7 @@ func CreateUser(context *gin.Context) {
         return
 	}
 
-	_, err := input.CreateUser()
+	_, err := input.CreateUser(currentActor(context))
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -61,7 +62,7 @@ func CreateUser(context *gin.Context) {
 
 func UpdateUser(context *gin.Context) {
 
-	var input models.User
+	var input models.UpdateUser
 	if err := context.ShouldBindJSON(&input); err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -73,7 +74,7 @@ func UpdateUser(context *gin.Context) {
         return
     }
 
-	_, err = input.UpdateUser(id)
+	_, err = input.UpdateUser(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -95,7 +96,7 @@ func DeleteUser(context *gin.Context) {
         return
     }
 	
-	_, err = input.DeleteUser(id)
+	_, err = input.DeleteUser(id, currentActor(context))
 	if err != nil {
 		if err == helper.ErrorRecordNotFound {
             context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -108,5 +109,102 @@ func DeleteUser(context *gin.Context) {
 	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
 }
 
+func RevokeUserSessions(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+
+	err = models.RevokeAllUserTokens(uint(id))
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "logout success"})
+}
+
+func UnlockUser(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+
+	err = models.UnlockUserLogin(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "unlock success"})
+}
+
+func GetAllLoginThrottles(context *gin.Context) {
+
+	data, err := models.GetAllLoginThrottles()
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": data})
+}
+
+func DeleteLoginThrottle(context *gin.Context) {
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login throttle ID"})
+        return
+    }
+
+	err = models.DeleteLoginThrottle(id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "delete success"})
+}
+
+func CreatePasswordReset(context *gin.Context) {
 
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+    if err != nil {
+        context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+
+	user_id, err := token.ExtractTokenID(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	resetToken, model, err := models.CreatePasswordResetToken(id, user_id)
+	if err != nil {
+		if err == helper.ErrorRecordNotFound {
+            context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "create success", "data": gin.H{"token": resetToken, "expires_at": model.ExpiresAt}})
+}
@@ -0,0 +1,77 @@
+package supplier
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+type LoginInput struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func Login(context *gin.Context) {
+
+	var input LoginInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := models.SupplierLoginCheck(input.Email, input.Password, context.ClientIP())
+
+	if err != nil {
+		if err == models.ErrorLoginLocked {
+			context.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": "email or password is incorrect."})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func CurrentSupplier(context *gin.Context) {
+
+	supplier_id, err := token.ExtractTokenSupplierID(context)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	model, err := models.GetSupplier(uint64(supplier_id))
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "success", "data": model})
+}
+
+func Logout(context *gin.Context) {
+
+	claims, err := token.ExtractTokenClaims(context)
+
+	if err != nil {
+		fmt.Println("Logout error:", err)
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	err = models.RevokeSupplierToken(claims.JTI, claims.SupplierID, claims.ExpiresAt)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
+}
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unicode"
+)
+
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// GetPasswordPolicy reads the policy from the PASSWORD_* env values,
+// anything not configured falls back to an 8 character minimum only
+func GetPasswordPolicy() PasswordPolicy {
+
+    policy := PasswordPolicy{MinLength: 8}
+
+    if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && minLength > 0 {
+        policy.MinLength = minLength
+    }
+
+    policy.RequireUpper, _ = strconv.ParseBool(os.Getenv("PASSWORD_REQUIRE_UPPER"))
+    policy.RequireLower, _ = strconv.ParseBool(os.Getenv("PASSWORD_REQUIRE_LOWER"))
+    policy.RequireDigit, _ = strconv.ParseBool(os.Getenv("PASSWORD_REQUIRE_DIGIT"))
+    policy.RequireSymbol, _ = strconv.ParseBool(os.Getenv("PASSWORD_REQUIRE_SYMBOL"))
+
+    return policy
+}
+
+func ValidatePasswordPolicy(password string) error {
+
+    policy := GetPasswordPolicy()
+
+    if len([]rune(password)) < policy.MinLength {
+        return fmt.Errorf("password must be at least %d characters", policy.MinLength)
+    }
+
+    var hasUpper, hasLower, hasDigit, hasSymbol bool
+
+    for _, r := range password {
+        switch {
+        case unicode.IsUpper(r):
+            hasUpper = true
+        case unicode.IsLower(r):
+            hasLower = true
+        case unicode.IsDigit(r):
+            hasDigit = true
+        case unicode.IsPunct(r) || unicode.IsSymbol(r):
+            hasSymbol = true
+        }
+    }
+
+    if policy.RequireUpper && !hasUpper {
+        return fmt.Errorf("password must contain an uppercase letter")
+    }
+    if policy.RequireLower && !hasLower {
+        return fmt.Errorf("password must contain a lowercase letter")
+    }
+    if policy.RequireDigit && !hasDigit {
+        return fmt.Errorf("password must contain a digit")
+    }
+    if policy.RequireSymbol && !hasSymbol {
+        return fmt.Errorf("password must contain a symbol")
+    }
+
+    return nil
+}
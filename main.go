@@ -1,21 +1,41 @@
 package main
 
 import (
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/cmd"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/routes"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
 )
 
 func main(){
 	models.ConnectDatabase()
 
+	if err := token.LoadKeySet(); err != nil {
+		log.Fatal("jwt keyset error:", err)
+	}
+
+	models.StartTokenPruner(time.Hour)
+	models.StartAlertScheduler(15 * time.Minute)
+	models.StartReservationExpirer(time.Minute)
+	models.StartPurchaseOrderMailer(time.Minute)
+
 	cmd.Execute()
 
 	r := gin.Default()
 
+	// X-Forwarded-For is only taken from these proxies, login lockouts are
+	// keyed on the client address
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal("trusted proxies error:", err)
+	}
+
 	// Router
 	routes.SetupRoutes(r)
 
@@ -25,6 +45,20 @@ func main(){
 }
 
 
+// trustedProxies reads TRUSTED_PROXIES, a comma separated list of addresses
+// or CIDRs, unset trusts no proxy
+func trustedProxies() []string {
+
+	var proxies []string
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func customNotFoundHandler(c *gin.Context) {
     c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 }
\ No newline at end of file
@@ -5,10 +5,76 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
 )
 
+const APIKeyContextKey = "api_key"
+
+// JwtAuthMiddleware accepts a staff JWT or an integration key sent in the X-API-Key header
 func JwtAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			// a user token next to the key would reach handlers that read
+			// the user from it without its revocation being checked
+			if token.ExtractToken(c) != "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "send either an API key or a token, not both"})
+				c.Abort()
+				return
+			}
+
+			key, err := models.AuthenticateAPIKey(apiKey)
+			if err != nil {
+				fmt.Println("API key validation error:", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				c.Abort()
+				return
+			}
+			c.Set(APIKeyContextKey, key)
+			c.Next()
+			return
+		}
+
+		err := token.TokenValid(c)
+		if err != nil {
+			fmt.Println("Token validation error:", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		claims, err := token.ExtractTokenClaims(c)
+		if err != nil || claims.Scope != token.ScopeUser {
+			fmt.Println("Token claims error:", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		revoked, err := models.IsTokenRevoked(claims.JTI, claims.UserID, claims.IssuedAt)
+		if err != nil || revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// UserOnlyMiddleware keeps API keys off routes that act for the signed in
+// user, they have no permission to check the key's scopes against
+func UserOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get(APIKeyContextKey); exists {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func SupplierAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		err := token.TokenValid(c)
 		if err != nil {
@@ -17,6 +83,48 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+
+		claims, err := token.ExtractTokenClaims(c)
+		if err != nil || claims.Scope != token.ScopeSupplier {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		revoked, err := models.IsSupplierTokenRevoked(claims.JTI, claims.SupplierID)
+		if err != nil || revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func PermissionMiddleware(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, exists := c.Get(APIKeyContextKey); exists {
+			if !value.(*models.APIKey).HasScope(permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		role, err := token.ExtractTokenRole(c)
+		if err != nil {
+			fmt.Println("Token role error:", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+		if !models.Role(role).HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+const apiKeyPrefix = "mk_"
+
+// APIKey lets integrations such as accounting sync call the API without
+// a staff login, only the hash of the key is stored
+type APIKey struct {
+	ID          uint         `gorm:"primary_key" json:"id"`
+	Name        string       `gorm:"size:255;not null" json:"name"`
+	Prefix      string       `gorm:"size:20;not null" json:"prefix"`
+	KeyHash     string       `gorm:"size:64;not null;unique" json:"-"`
+	Scopes      []Permission `gorm:"type:text;serializer:json" json:"scopes"`
+	ExpiresAt   *time.Time   `json:"expires_at"`
+	LastUsedAt  *time.Time   `json:"last_used_at"`
+	RevokedAt   *time.Time   `json:"revoked_at"`
+	CreatedBy   uint         `gorm:"not null" json:"created_by"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+type CreateAPIKey struct {
+	Name      string       `json:"name" validate:"required,min=3,max=100"`
+	Scopes    []Permission `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+}
+
+var ErrorInvalidAPIKey = errors.New("invalid api key")
+
+func (key *APIKey) HasScope(permission Permission) bool {
+
+	for _, scope := range key.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func GetAllAPIKeys() ([]APIKey, error) {
+
+	var results []APIKey
+
+	if err := DB.Order("created_at desc").Find(&results).Error; err != nil {
+		return results, errors.New("no api key")
+	}
+
+	return results, nil
+}
+
+func GetAPIKey(id uint64) (APIKey, error) {
+
+	var result APIKey
+
+	if err := DB.First(&result, id).Error; err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+// CreateAPIKey returns the plain key, it is shown once and cannot be recovered
+func (input *CreateAPIKey) CreateAPIKey(actor Actor) (string, *APIKey, error) {
+
+	for _, scope := range input.Scopes {
+		if !IsValidPermission(scope) {
+			return "", nil, errors.New("invalid scope " + string(scope))
+		}
+		if scope == PermUsersManage || scope == PermAPIKeysManage {
+			return "", nil, errors.New("api keys cannot manage users or api keys")
+		}
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return "", nil, errors.New("expires_at must be in the future")
+	}
+
+	secret, err := token.GenerateRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	plainKey := apiKeyPrefix + secret
+
+	result := APIKey{
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    plainKey[:len(apiKeyPrefix)+8],
+		KeyHash:   token.HashToken(plainKey),
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+		CreatedBy: actor.UserId,
+	}
+
+	if err := DB.Create(&result).Error; err != nil {
+		return "", nil, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "api_keys", result.ID, nil, result)
+
+	return plainKey, &result, nil
+}
+
+func RevokeAPIKey(id uint64, actor Actor) (*APIKey, error) {
+
+	var result APIKey
+
+	if err := DB.First(&result, id).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if result.RevokedAt != nil {
+		return &result, nil
+	}
+
+	before := result
+
+	now := time.Now()
+	result.RevokedAt = &now
+
+	if err := DB.Model(&result).UpdateColumn("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+
+	recordAudit(DB, actor, AuditUpdate, "api_keys", result.ID, before, result)
+
+	return &result, nil
+}
+
+func AuthenticateAPIKey(plainKey string) (*APIKey, error) {
+
+	if !strings.HasPrefix(plainKey, apiKeyPrefix) {
+		return nil, ErrorInvalidAPIKey
+	}
+
+	var result APIKey
+
+	if err := DB.Where("key_hash = ?", token.HashToken(plainKey)).First(&result).Error; err != nil {
+		return nil, ErrorInvalidAPIKey
+	}
+
+	now := time.Now()
+
+	if result.RevokedAt != nil || (result.ExpiresAt != nil && now.After(*result.ExpiresAt)) {
+		return nil, ErrorInvalidAPIKey
+	}
+
+	// a minute is precise enough and saves a write on every request
+	if result.LastUsedAt == nil || now.Sub(*result.LastUsedAt) > time.Minute {
+		if err := DB.Model(&result).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+		result.LastUsedAt = &now
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+)
+
+// Actor is whoever made a change, a staff user or an integration API key
+type Actor struct {
+	UserId   uint
+	APIKeyId uint
+}
+
+type AuditLog struct {
+	ID          uint        `gorm:"primary_key" json:"id"`
+	ActorId     uint        `gorm:"index;not null;default:0" json:"actor_id"`
+	ApiKeyId    uint        `gorm:"index;not null;default:0" json:"api_key_id"`
+	EntityType  string      `gorm:"size:100;index:idx_audit_entity;not null" json:"entity_type"`
+	EntityId    uint        `gorm:"index:idx_audit_entity;not null" json:"entity_id"`
+	Action      AuditAction `gorm:"size:50;index;not null" json:"action"`
+	Before      string      `gorm:"type:longtext" json:"before"`
+	After       string      `gorm:"type:longtext" json:"after"`
+	Changes     string      `gorm:"type:longtext" json:"changes"`
+	CreatedAt   time.Time   `gorm:"index" json:"created_at"`
+}
+
+// secret values never go into the audit trail
+var auditHiddenFields = map[string]bool{
+	"password":    true,
+	"totp_secret": true,
+}
+
+// auditSkippedFields change on every save and only add noise to the diff
+var auditSkippedFields = map[string]bool{
+	"updated_at": true,
+}
+
+func toAuditMap(value interface{}) (map[string]interface{}, error) {
+
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+
+	for field := range auditHiddenFields {
+		delete(result, field)
+	}
+
+	return result, nil
+}
+
+func auditDiff(before map[string]interface{}, after map[string]interface{}) map[string]interface{} {
+
+	changes := make(map[string]interface{})
+
+	for field, afterValue := range after {
+		if auditSkippedFields[field] {
+			continue
+		}
+		beforeValue, exists := before[field]
+		if !exists || !reflect.DeepEqual(beforeValue, afterValue) {
+			changes[field] = map[string]interface{}{"from": beforeValue, "to": afterValue}
+		}
+	}
+
+	for field, beforeValue := range before {
+		if _, exists := after[field]; !exists && !auditSkippedFields[field] {
+			changes[field] = map[string]interface{}{"from": beforeValue, "to": nil}
+		}
+	}
+
+	return changes
+}
+
+func marshalAuditValue(value interface{}) string {
+
+	if value == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// recordAudit writes one audit row with db so it commits or rolls back
+// together with the change, a failing audit write never fails the change
+func recordAudit(db *gorm.DB, actor Actor, action AuditAction, entityType string, entityId uint, before interface{}, after interface{}) {
+
+	beforeMap, err := toAuditMap(before)
+	if err != nil {
+		fmt.Println("Audit log error:", err)
+		return
+	}
+
+	afterMap, err := toAuditMap(after)
+	if err != nil {
+		fmt.Println("Audit log error:", err)
+		return
+	}
+
+	changes := auditDiff(beforeMap, afterMap)
+
+	if action == AuditUpdate && len(changes) == 0 {
+		return
+	}
+
+	log := AuditLog{
+		ActorId:    actor.UserId,
+		ApiKeyId:   actor.APIKeyId,
+		EntityType: entityType,
+		EntityId:   entityId,
+		Action:     action,
+		Changes:    marshalAuditValue(changes),
+	}
+	if beforeMap != nil {
+		log.Before = marshalAuditValue(beforeMap)
+	}
+	if afterMap != nil {
+		log.After = marshalAuditValue(afterMap)
+	}
+
+	if err := db.Create(&log).Error; err != nil {
+		fmt.Println("Audit log error:", err)
+	}
+}
+
+func GetAllAuditLogs(c *gin.Context) ([]AuditLog, error) {
+
+	var results []AuditLog
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	actorId := c.Query("actor_id")
+	apiKeyId := c.Query("api_key_id")
+	entityType := c.Query("entity_type")
+	entityId := c.Query("entity_id")
+	action := c.Query("action")
+	fromDate := c.Query("from_date")
+	toDate := c.Query("to_date")
+
+	db := DB.Model(&AuditLog{})
+
+	if actorId != "" {
+		db = db.Where("actor_id = ?", actorId)
+	}
+	if apiKeyId != "" {
+		db = db.Where("api_key_id = ?", apiKeyId)
+	}
+	if entityType != "" {
+		db = db.Where("entity_type = ?", entityType)
+	}
+	if entityId != "" {
+		db = db.Where("entity_id = ?", entityId)
+	}
+	if action != "" {
+		db = db.Where("action = ?", action)
+	}
+	if fromDate != "" {
+		from, err := time.Parse("2006-01-02", fromDate)
+		if err != nil {
+			return results, errors.New("invalid from_date")
+		}
+		db = db.Where("created_at >= ?", from)
+	}
+	if toDate != "" {
+		to, err := time.Parse("2006-01-02", toDate)
+		if err != nil {
+			return results, errors.New("invalid to_date")
+		}
+		db = db.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	if err := utils.Paginate(db, pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no audit logs")
+	}
+
+	return results, nil
+}
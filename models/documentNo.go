@@ -0,0 +1,35 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// nextDocumentNo numbers a new document of model's table with prefix from
+// its last row, deleted rows included. Call it from BeforeCreate with the
+// hook's tx, the last row stays locked until the create commits so two
+// creates cannot take the same number
+func nextDocumentNo(tx *gorm.DB, model interface{}, prefix string) (string, error) {
+
+	var lastIds []uint
+
+	err := tx.Session(&gorm.Session{NewDB: true}).
+			Unscoped().
+			Model(model).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Order("id DESC").
+			Limit(1).
+			Pluck("id", &lastIds).Error
+	if err != nil {
+		return "", err
+	}
+
+	var lastId uint
+	if len(lastIds) > 0 {
+		lastId = lastIds[0]
+	}
+
+	return fmt.Sprintf("%s%05d", prefix, lastId+1), nil
+}
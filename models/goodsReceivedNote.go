@@ -0,0 +1,268 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GoodsReceivedNoteStatus string
+
+const (
+	GoodsReceivedNotePosted GoodsReceivedNoteStatus = "posted"
+	GoodsReceivedNoteVoided GoodsReceivedNoteStatus = "voided"
+)
+
+// GoodsReceivedNote is one delivery booked in against a purchase order,
+// the stock it brought in is recorded under the note
+type GoodsReceivedNote struct {
+	ID                     uint                    `gorm:"primary_key" json:"id"`
+	GrnNo                  string                  `gorm:"index;size:255;unique" json:"grn_no"`
+	PurchaseOrder          *PurchaseOrder          `gorm:"foreignKey:PurchaseOrderId" json:"purchase_order,omitempty"`
+	PurchaseOrderId        uint                    `gorm:"index;not null" json:"purchase_order_id"`
+	Warehouse              *Warehouse              `gorm:"foreignKey:WarehouseId" json:"warehouse,omitempty"`
+	WarehouseId            uint                    `gorm:"index;not null" json:"warehouse_id"`
+	Status                 GoodsReceivedNoteStatus `gorm:"type:enum('posted', 'voided');default:'posted'" json:"status"`
+	DeliveryNoteNo         string                  `gorm:"size:255" json:"delivery_note_no"`
+	DriverName             string                  `gorm:"size:255" json:"driver_name"`
+	VehicleNo              string                  `gorm:"size:100" json:"vehicle_no"`
+	ReceiverName           string                  `gorm:"size:255" json:"receiver_name"`
+	ReceivedBy             uint                    `gorm:"not null;default:0" json:"received_by"`
+	ReceivedAt             time.Time               `gorm:"index" json:"received_at"`
+	Note                   string                  `gorm:"type:text" json:"note"`
+	TotalQty               float64                 `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_qty"`
+	VoidedBy               uint                    `gorm:"not null;default:0" json:"voided_by"`
+	VoidedAt               *time.Time              `json:"voided_at"`
+	VoidReason             string                  `gorm:"type:text" json:"void_reason"`
+	GoodsReceivedNoteItems []GoodsReceivedNoteItem `gorm:"foreignKey:GoodsReceivedNoteId" json:"goods_received_note_items"`
+	CreatedAt              time.Time               `json:"created_at"`
+	UpdatedAt              time.Time               `json:"updated_at"`
+}
+
+type GoodsReceivedNoteItem struct {
+	ID                  uint    `gorm:"primary_key" json:"id"`
+	GoodsReceivedNoteId uint    `gorm:"index;not null" json:"goods_received_note_id"`
+	PurchaseOrderItemId uint    `gorm:"index;not null" json:"purchase_order_item_id"`
+	ProductVariationId  uint    `gorm:"index;not null" json:"product_variation_id"`
+	ProductName         string  `gorm:"size:255" json:"product_name"`
+	Qty                 float64 `gorm:"type:decimal(12,2);not null" json:"qty"`
+	UnitCost            float64 `gorm:"type:decimal(12,2);not null;default:0.0" json:"unit_cost"`
+}
+
+type VoidGoodsReceivedNote struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+}
+
+// BeforeCreate numbers the note, only on create so later saves keep their number
+func (g *GoodsReceivedNote) BeforeCreate(tx *gorm.DB) error {
+
+	no, err := nextDocumentNo(tx, &GoodsReceivedNote{}, "G")
+	if err != nil {
+		return err
+	}
+	g.GrnNo = no
+
+	return nil
+}
+
+func (g *GoodsReceivedNote) stockSource() StockSource {
+	return StockSource{Type: "goods_received_notes", Id: g.ID, No: g.GrnNo}
+}
+
+func GetPurchaseOrderGoodsReceivedNotes(purchaseOrderId uint64) ([]GoodsReceivedNote, error) {
+
+	var results []GoodsReceivedNote
+
+	if err := DB.First(&PurchaseOrder{}, purchaseOrderId).Error; err != nil {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	err := DB.Preload("GoodsReceivedNoteItems").
+			Preload("Warehouse").
+			Where("purchase_order_id = ?", purchaseOrderId).
+			Order("received_at, id").
+			Find(&results).Error
+	if err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
+func GetGoodsReceivedNote(id uint64) (GoodsReceivedNote, error) {
+
+	var result GoodsReceivedNote
+
+	err := DB.Preload("GoodsReceivedNoteItems").
+			Preload("Warehouse").
+			Preload("PurchaseOrder").
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+// VoidGoodsReceivedNote takes the goods of the note back out of the
+// warehouse lot by lot and returns the qtys to the order lines. An order
+// the receipt had closed goes back to sent
+func (input *VoidGoodsReceivedNote) VoidGoodsReceivedNote(id uint64, actor Actor) (*GoodsReceivedNote, error) {
+
+	tx := DB.Begin()
+
+	// the note row stays locked until commit, a second void waits here
+	// and then finds it voided
+	var existingNote GoodsReceivedNote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("GoodsReceivedNoteItems").First(&existingNote, id).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, helper.ErrorRecordNotFound
+	}
+
+	if existingNote.Status != GoodsReceivedNotePosted {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("goods received note is already voided")
+	}
+
+	var existingPurchaseOrder PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, existingNote.PurchaseOrderId).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("error fetching purchase order")
+	}
+
+	var beforePurchaseOrder PurchaseOrder
+	if err := tx.Preload("PurchaseOrderItems").First(&beforePurchaseOrder, existingNote.PurchaseOrderId).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("error fetching purchase order")
+	}
+
+	before := existingNote
+
+	// only an order closed by its full receipt goes back to sent
+	autoClosed := existingPurchaseOrder.Status == PurchaseOrderClosed && existingPurchaseOrder.TotalRemainingQty == 0
+
+	// every movement of the note is reversed on its own lot, the goods
+	// must still be there
+	var movements []StockMovement
+
+	source := existingNote.stockSource()
+	err := tx.Where("source_type = ? AND source_id = ?", source.Type, source.Id).
+			Order("product_variation_id, id").
+			Find(&movements).Error
+	if err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
+	}
+
+	for _, movement := range movements {
+		if err := lockVariationStock(tx, movement.ProductVariationId); err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+
+		onHand, err := getWarehouseOnHandQty(lockingRead(tx), movement.ProductVariationId, movement.WarehouseId)
+		if err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+
+		// a lot can run out while the warehouse still holds other lots
+		if movement.LotId != 0 {
+			err = lockingRead(tx).Model(&StockMovement{}).
+					Select("COALESCE(SUM(qty), 0)").
+					Where("product_variation_id = ? AND warehouse_id = ? AND lot_id = ?", movement.ProductVariationId, movement.WarehouseId, movement.LotId).
+					Scan(&onHand).Error
+			if err != nil {
+				tx.Rollback()
+				return &GoodsReceivedNote{}, err
+			}
+		}
+
+		if onHand < movement.Qty {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, fmt.Errorf("goods of product variation %d were already used, the note cannot be voided", movement.ProductVariationId)
+		}
+
+		if err := recordStockMovement(tx, actor, source, StockMovement{
+			ProductVariationId: movement.ProductVariationId,
+			WarehouseId:        movement.WarehouseId,
+			LotId:              movement.LotId,
+			Qty:                -movement.Qty,
+			Reason:             ReasonPurchaseReceipt,
+			UnitCost:           movement.UnitCost,
+			Note:               "void " + existingNote.GrnNo,
+		}); err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+	}
+
+	for _, item := range existingNote.GoodsReceivedNoteItems {
+		var existingItem PurchaseOrderItem
+
+		if err := tx.Where("id = ? AND purchase_order_id = ?", item.PurchaseOrderItemId, existingNote.PurchaseOrderId).First(&existingItem).Error; err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+
+		existingItem.TotalReceivedQty -= item.Qty
+		existingItem.TotalRemainingQty += item.Qty
+
+		if existingItem.TotalReceivedQty > 0 {
+			existingItem.ReceivedStatus = Partial
+		} else {
+			existingItem.ReceivedStatus = Pending
+		}
+
+		if err := tx.Save(&existingItem).Error; err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+
+		existingPurchaseOrder.TotalReceivedQty -= item.Qty
+		existingPurchaseOrder.TotalRemainingQty += item.Qty
+	}
+
+	if existingPurchaseOrder.TotalReceivedQty > 0 {
+		existingPurchaseOrder.ReceivedStatus = Partial
+	} else {
+		existingPurchaseOrder.ReceivedStatus = Pending
+	}
+
+	// an order closed by hand while goods were still owed stays closed
+	if autoClosed && existingPurchaseOrder.TotalRemainingQty > 0 {
+		existingPurchaseOrder.Status = PurchaseOrderSent
+		existingPurchaseOrder.ClosedAt = nil
+	}
+
+	if err := tx.Save(&existingPurchaseOrder).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
+	}
+
+	recordPurchaseOrderAudit(tx, actor, beforePurchaseOrder)
+
+	now := time.Now()
+	existingNote.Status = GoodsReceivedNoteVoided
+	existingNote.VoidedBy = actor.UserId
+	existingNote.VoidedAt = &now
+	existingNote.VoidReason = input.Reason
+
+	if err := tx.Omit("GoodsReceivedNoteItems").Save(&existingNote).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
+	}
+
+	recordAudit(tx, actor, AuditUpdate, "goods_received_notes", existingNote.ID, before, existingNote)
+
+	if err := tx.Commit().Error; err != nil {
+		return &GoodsReceivedNote{}, err
+	}
+
+	return &existingNote, nil
+}
@@ -27,7 +27,7 @@ func transformImageURLs(images []Image) []Image {
 	return images
 }
 
-func (input *Image) UploadImage() (*Image, error) {
+func (input *Image) UploadImage(actor Actor) (*Image, error) {
 
 	if input.OwnerType != "products" && input.OwnerType != "product_variations"{
 		return &Image{}, errors.New("invalid image owner type")
@@ -67,10 +67,13 @@ func (input *Image) UploadImage() (*Image, error) {
 	if err != nil {
 		return &Image{}, err
 	}
+
+	recordAudit(DB, actor, AuditCreate, "images", input.ID, nil, input)
+
 	return input, nil
 }
 
-func (input *Image) DeleteImage(id uint64) (*Image, error) {
+func (input *Image) DeleteImage(id uint64, actor Actor) (*Image, error) {
 
 	err := DB.Model(&Image{}).Where("id = ?", id).First(&input).Error
 	if  err != nil {
@@ -85,5 +88,8 @@ func (input *Image) DeleteImage(id uint64) (*Image, error) {
 	if err != nil {
 		return &Image{}, err
 	}
+
+	recordAudit(DB, actor, AuditDelete, "images", input.ID, input, nil)
+
 	return input, nil
 }
\ No newline at end of file
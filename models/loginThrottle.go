@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LoginMaxAttempts    = 5
+	LoginIPMaxAttempts  = 50
+	LoginLockoutBase    = time.Minute
+	LoginLockoutMax     = 24 * time.Hour
+	LoginAttemptsWindow = 15 * time.Minute
+)
+
+var ErrorLoginLocked = errors.New("too many failed login attempts, try again later")
+
+// LoginThrottle counts failed logins for one key, either "username:<name>" or "ip:<address>"
+type LoginThrottle struct {
+	ID           uint       `gorm:"primary_key" json:"id"`
+	Key          string     `gorm:"size:255;not null;unique" json:"key"`
+	FailedCount  int        `gorm:"not null;default:0" json:"failed_count"`
+	LockedUntil  *time.Time `json:"locked_until"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func usernameThrottleKey(username string) string {
+	return "username:" + username
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// maxAttemptsFor gives an address more tries than an account, staff behind
+// one office NAT share it
+func maxAttemptsFor(key string) int {
+
+	if strings.HasPrefix(key, "ip:") {
+		return LoginIPMaxAttempts
+	}
+	return LoginMaxAttempts
+}
+
+func isLoginLocked(keys ...string) (bool, error) {
+
+	var count int64
+
+	err := DB.Model(&LoginThrottle{}).Where("`key` IN ? AND locked_until > ?", keys, time.Now()).Count(&count).Error
+	if err != nil {
+		return true, err
+	}
+
+	return count > 0, nil
+}
+
+// lockoutDuration doubles for every failure past maxAttempts
+func lockoutDuration(failedCount int, maxAttempts int) time.Duration {
+
+	if failedCount < maxAttempts {
+		return 0
+	}
+
+	duration := LoginLockoutBase * time.Duration(math.Pow(2, float64(failedCount-maxAttempts)))
+	if duration <= 0 || duration > LoginLockoutMax {
+		return LoginLockoutMax
+	}
+
+	return duration
+}
+
+// recordLoginFailure counts the failure in one upsert, parallel guesses
+// for a key queue on its row and each sees the count it produced
+func recordLoginFailure(keys ...string) error {
+
+	for _, key := range keys {
+		if err := recordKeyLoginFailure(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func recordKeyLoginFailure(key string) error {
+
+	now := time.Now()
+
+	tx := DB.Begin()
+
+	// old failures outside the window no longer count
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: []clause.Assignment{
+			{Column: clause.Column{Name: "failed_count"}, Value: gorm.Expr(
+				"IF(last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?), 1, failed_count + 1)",
+				now.Add(-LoginAttemptsWindow), now)},
+			{Column: clause.Column{Name: "last_failed_at"}, Value: now},
+			{Column: clause.Column{Name: "updated_at"}, Value: now},
+		},
+	}).Create(&LoginThrottle{Key: key, FailedCount: 1, LastFailedAt: now}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var throttle LoginThrottle
+	if err := tx.Where("`key` = ?", key).First(&throttle).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if duration := lockoutDuration(throttle.FailedCount, maxAttemptsFor(key)); duration > 0 {
+		if err := tx.Model(&throttle).UpdateColumn("locked_until", now.Add(duration)).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func clearLoginFailures(keys ...string) error {
+
+	return DB.Where("`key` IN ?", keys).Delete(&LoginThrottle{}).Error
+}
+
+func GetAllLoginThrottles() ([]LoginThrottle, error) {
+
+	var results []LoginThrottle
+
+	if err := DB.Order("updated_at desc").Find(&results).Error; err != nil {
+		return results, errors.New("no login throttle")
+	}
+
+	return results, nil
+}
+
+func UnlockUserLogin(id uint64) error {
+
+	var user User
+
+	if err := DB.First(&user, id).Error; err != nil {
+		return helper.ErrorRecordNotFound
+	}
+
+	return clearLoginFailures(usernameThrottleKey(user.Username))
+}
+
+func DeleteLoginThrottle(id uint64) error {
+
+	var throttle LoginThrottle
+
+	if err := DB.First(&throttle, id).Error; err != nil {
+		return helper.ErrorRecordNotFound
+	}
+
+	return DB.Delete(&throttle).Error
+}
@@ -0,0 +1,453 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationType string
+
+const (
+	NotificationLowStock             NotificationType = "low_stock"
+	NotificationOutOfStock           NotificationType = "out_of_stock"
+	NotificationLotExpiring          NotificationType = "lot_expiring"
+	NotificationPurchaseOrderOverdue NotificationType = "po_overdue"
+)
+
+type Notification struct {
+	ID         uint             `gorm:"primary_key" json:"id"`
+	UserId     uint             `gorm:"index;not null" json:"user_id"`
+	Type       NotificationType `gorm:"type:enum('low_stock', 'out_of_stock', 'lot_expiring', 'po_overdue');not null" json:"type"`
+	Title      string           `gorm:"size:255;not null" json:"title"`
+	Message    string           `gorm:"type:text" json:"message"`
+	EntityType string           `gorm:"size:100" json:"entity_type"`
+	EntityId   uint             `gorm:"not null;default:0" json:"entity_id"`
+	AlertKey   string           `gorm:"size:255;index" json:"-"`
+	ReadAt     *time.Time       `json:"read_at"`
+	CreatedAt  time.Time        `gorm:"index" json:"created_at"`
+}
+
+// ActiveAlert remembers a condition users were told about, so the
+// scheduler notifies once per episode instead of on every run. The row goes
+// away when the condition clears and the next time it happens is new
+type ActiveAlert struct {
+	ID        uint             `gorm:"primary_key" json:"id"`
+	AlertKey  string           `gorm:"size:255;not null;unique" json:"alert_key"`
+	Type      NotificationType `gorm:"size:50;not null" json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// alert is one condition found by a scheduler run
+type alert struct {
+	Key        string
+	Type       NotificationType
+	Title      string
+	Message    string
+	EntityType string
+	EntityId   uint
+}
+
+// alertPermissions decides who hears about what, users who can see the
+// stock or the purchase orders
+var alertPermissions = map[NotificationType]Permission{
+	NotificationLowStock:             PermWarehousesRead,
+	NotificationOutOfStock:           PermWarehousesRead,
+	NotificationLotExpiring:          PermWarehousesRead,
+	NotificationPurchaseOrderOverdue: PermPurchaseOrdersRead,
+}
+
+func alertExpiryDays() int {
+
+	days, err := strconv.Atoi(os.Getenv("ALERT_LOT_EXPIRY_DAYS"))
+	if err != nil || days < 0 {
+		return 7
+	}
+	return days
+}
+
+func GetAllNotifications(c *gin.Context, userId uint) ([]Notification, error) {
+
+	var results []Notification
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	notificationType := c.Query("type")
+	unread := c.Query("unread")
+
+	db := DB.Model(&Notification{}).Where("user_id = ?", userId)
+
+	if notificationType != "" {
+		db = db.Where("type = ?", notificationType)
+	}
+	if unread == "true" {
+		db = db.Where("read_at IS NULL")
+	}
+
+	if err := utils.Paginate(db, pageParam, perPageParam, &results, "created_at", "desc"); err != nil {
+		return results, errors.New("no notifications")
+	}
+
+	return results, nil
+}
+
+func CountUnreadNotifications(userId uint) (int64, error) {
+
+	var count int64
+
+	err := DB.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userId).Count(&count).Error
+
+	return count, err
+}
+
+// MarkNotification sets or clears the read time of the user's own notification
+func MarkNotification(userId uint, id uint64, read bool) (*Notification, error) {
+
+	var notification Notification
+
+	if err := DB.Where("id = ? AND user_id = ?", id, userId).First(&notification).Error; err != nil {
+		return &Notification{}, helper.ErrorRecordNotFound
+	}
+
+	if read && notification.ReadAt != nil {
+		return &notification, nil
+	}
+
+	var readAt *time.Time
+	if read {
+		now := time.Now()
+		readAt = &now
+	}
+
+	if err := DB.Model(&Notification{}).Where("id = ?", notification.ID).Update("read_at", readAt).Error; err != nil {
+		return &Notification{}, err
+	}
+	notification.ReadAt = readAt
+
+	return &notification, nil
+}
+
+func MarkAllNotificationsRead(userId uint) (int64, error) {
+
+	result := DB.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Update("read_at", time.Now())
+
+	return result.RowsAffected, result.Error
+}
+
+func findLowStockAlerts() ([]alert, error) {
+
+	var rows []struct {
+		ProductVariationId uint
+		WarehouseId        uint
+		Title              string
+		VariantName        string
+		WarehouseName      string
+		MinQty             float64
+		OnHandQty          float64
+	}
+
+	err := DB.Table("reorder_points").
+		Select("reorder_points.product_variation_id, reorder_points.warehouse_id, products.title, "+
+			"product_variations.variant_name, warehouses.name AS warehouse_name, reorder_points.min_qty, "+
+			"COALESCE(SUM(stock_movements.qty), 0) AS on_hand_qty").
+		Joins("JOIN product_variations ON product_variations.id = reorder_points.product_variation_id").
+		Joins("JOIN products ON products.id = product_variations.product_id").
+		Joins("JOIN warehouses ON warehouses.id = reorder_points.warehouse_id").
+		Joins("LEFT JOIN stock_movements ON stock_movements.product_variation_id = reorder_points.product_variation_id "+
+			"AND stock_movements.warehouse_id = reorder_points.warehouse_id").
+		Where("products.is_qty_tracked = ? AND products.deleted_at IS NULL", true).
+		Where("warehouses.deleted_at IS NULL AND warehouses.is_active = ?", true).
+		Group("reorder_points.id, reorder_points.product_variation_id, reorder_points.warehouse_id, products.title, " +
+			"product_variations.variant_name, warehouses.name, reorder_points.min_qty").
+		Having("COALESCE(SUM(stock_movements.qty), 0) > 0 AND COALESCE(SUM(stock_movements.qty), 0) < reorder_points.min_qty").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var results []alert
+	for _, row := range rows {
+		results = append(results, alert{
+			Key:        fmt.Sprintf("low_stock:%d:%d", row.ProductVariationId, row.WarehouseId),
+			Type:       NotificationLowStock,
+			Title:      fmt.Sprintf("Low stock: %s %s", row.Title, row.VariantName),
+			Message:    fmt.Sprintf("%s has %.2f left at %s, minimum is %.2f", row.Title, row.OnHandQty, row.WarehouseName, row.MinQty),
+			EntityType: "product_variations",
+			EntityId:   row.ProductVariationId,
+		})
+	}
+	return results, nil
+}
+
+// findOutOfStockAlerts covers everything that was ever stocked at a
+// warehouse and has nothing left there, and everything with a reorder
+// point at a warehouse that holds none of it, stocked there before or not
+func findOutOfStockAlerts() ([]alert, error) {
+
+	type outOfStockRow struct {
+		ProductVariationId uint
+		WarehouseId        uint
+		Title              string
+		VariantName        string
+		WarehouseName      string
+	}
+
+	var rows, reorderRows []outOfStockRow
+
+	err := DB.Table("stock_movements").
+		Select("stock_movements.product_variation_id, stock_movements.warehouse_id, products.title, "+
+			"product_variations.variant_name, warehouses.name AS warehouse_name").
+		Joins("JOIN product_variations ON product_variations.id = stock_movements.product_variation_id").
+		Joins("JOIN products ON products.id = product_variations.product_id").
+		Joins("JOIN warehouses ON warehouses.id = stock_movements.warehouse_id").
+		Where("products.is_qty_tracked = ? AND products.deleted_at IS NULL", true).
+		Where("warehouses.deleted_at IS NULL AND warehouses.is_active = ?", true).
+		Group("stock_movements.product_variation_id, stock_movements.warehouse_id, products.title, " +
+			"product_variations.variant_name, warehouses.name").
+		Having("SUM(stock_movements.qty) <= 0").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = DB.Table("reorder_points").
+		Select("reorder_points.product_variation_id, reorder_points.warehouse_id, products.title, "+
+			"product_variations.variant_name, warehouses.name AS warehouse_name").
+		Joins("JOIN product_variations ON product_variations.id = reorder_points.product_variation_id").
+		Joins("JOIN products ON products.id = product_variations.product_id").
+		Joins("JOIN warehouses ON warehouses.id = reorder_points.warehouse_id").
+		Joins("LEFT JOIN stock_movements ON stock_movements.product_variation_id = reorder_points.product_variation_id "+
+			"AND stock_movements.warehouse_id = reorder_points.warehouse_id").
+		Where("products.is_qty_tracked = ? AND products.deleted_at IS NULL", true).
+		Where("warehouses.deleted_at IS NULL AND warehouses.is_active = ?", true).
+		Group("reorder_points.id, reorder_points.product_variation_id, reorder_points.warehouse_id, products.title, " +
+			"product_variations.variant_name, warehouses.name").
+		Having("COALESCE(SUM(stock_movements.qty), 0) <= 0").
+		Scan(&reorderRows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+
+	var results []alert
+	for _, row := range append(rows, reorderRows...) {
+		key := fmt.Sprintf("out_of_stock:%d:%d", row.ProductVariationId, row.WarehouseId)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		results = append(results, alert{
+			Key:        key,
+			Type:       NotificationOutOfStock,
+			Title:      fmt.Sprintf("Out of stock: %s %s", row.Title, row.VariantName),
+			Message:    fmt.Sprintf("%s %s is out of stock at %s", row.Title, row.VariantName, row.WarehouseName),
+			EntityType: "product_variations",
+			EntityId:   row.ProductVariationId,
+		})
+	}
+	return results, nil
+}
+
+func findLotExpiringAlerts() ([]alert, error) {
+
+	lots, err := getExpiringLots(alertExpiryDays(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var results []alert
+	for _, lot := range lots {
+		when := fmt.Sprintf("expires in %d days", lot.DaysToExpiry)
+		if lot.DaysToExpiry < 0 {
+			when = "has expired"
+		} else if lot.DaysToExpiry == 0 {
+			when = "expires today"
+		}
+
+		results = append(results, alert{
+			Key:        fmt.Sprintf("lot_expiring:%d:%d", lot.LotId, lot.WarehouseId),
+			Type:       NotificationLotExpiring,
+			Title:      fmt.Sprintf("Lot %s of %s %s", lot.LotNo, lot.Title, when),
+			Message:    fmt.Sprintf("%.2f of %s %s lot %s at %s %s", lot.OnHandQty, lot.Title, lot.VariantName, lot.LotNo, lot.WarehouseName, when),
+			EntityType: "stock_lots",
+			EntityId:   lot.LotId,
+		})
+	}
+	return results, nil
+}
+
+func findPurchaseOrderOverdueAlerts() ([]alert, error) {
+
+	var orders []PurchaseOrder
+
+	err := DB.Preload("Supplier").
+		Where("expected_delivery_date IS NOT NULL AND expected_delivery_date < ?", time.Now()).
+		Where("status = ? AND received_status <> ?", PurchaseOrderSent, Complete).
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var results []alert
+	for _, order := range orders {
+		supplierName := ""
+		if order.Supplier != nil {
+			supplierName = order.Supplier.Name
+		}
+
+		results = append(results, alert{
+			Key:        fmt.Sprintf("po_overdue:%d", order.ID),
+			Type:       NotificationPurchaseOrderOverdue,
+			Title:      fmt.Sprintf("Purchase order %s is overdue", order.OrderNo),
+			Message:    fmt.Sprintf("%s was expected from %s on %s and is not fully received", order.OrderNo, supplierName, order.ExpectedDeliveryDate.Format("2006-01-02")),
+			EntityType: "purchase_orders",
+			EntityId:   order.ID,
+		})
+	}
+	return results, nil
+}
+
+// notifyAlert sends one new alert to every active user allowed to see it,
+// taking the ActiveAlert key first so two servers do not both send it
+func notifyAlert(users []User, found alert) (bool, error) {
+
+	notified := false
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+
+		// another instance raising the same alert at once inserts nothing
+		// here and leaves the notifying to the one that did
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&ActiveAlert{AlertKey: found.Key, Type: found.Type})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		for _, user := range users {
+			if !user.Role.HasPermission(alertPermissions[found.Type]) {
+				continue
+			}
+
+			notification := Notification{
+				UserId:     user.ID,
+				Type:       found.Type,
+				Title:      found.Title,
+				Message:    found.Message,
+				EntityType: found.EntityType,
+				EntityId:   found.EntityId,
+				AlertKey:   found.Key,
+			}
+			if err := tx.Create(&notification).Error; err != nil {
+				return err
+			}
+		}
+
+		notified = true
+		return nil
+	})
+
+	return notified, err
+}
+
+// RunAlerts checks every alert condition once, notifies about the new ones
+// and forgets the ones that cleared
+func RunAlerts() (int, error) {
+
+	finders := []func() ([]alert, error){
+		findLowStockAlerts,
+		findOutOfStockAlerts,
+		findLotExpiringAlerts,
+		findPurchaseOrderOverdueAlerts,
+	}
+
+	var alerts []alert
+	for _, finder := range finders {
+		found, err := finder()
+		if err != nil {
+			return 0, err
+		}
+		alerts = append(alerts, found...)
+	}
+
+	var active []ActiveAlert
+	if err := DB.Find(&active).Error; err != nil {
+		return 0, err
+	}
+
+	activeKeys := make(map[string]bool)
+	for _, activeAlert := range active {
+		activeKeys[activeAlert.AlertKey] = true
+	}
+
+	var users []User
+	if err := DB.Where("is_active = ?", true).Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	currentKeys := make(map[string]bool)
+	created := 0
+
+	for _, current := range alerts {
+		currentKeys[current.Key] = true
+		if activeKeys[current.Key] {
+			continue
+		}
+		notified, err := notifyAlert(users, current)
+		if err != nil {
+			return created, err
+		}
+		if notified {
+			created++
+		}
+	}
+
+	var clearedKeys []string
+	for _, activeAlert := range active {
+		if !currentKeys[activeAlert.AlertKey] {
+			clearedKeys = append(clearedKeys, activeAlert.AlertKey)
+		}
+	}
+	if len(clearedKeys) > 0 {
+		if err := DB.Where("alert_key IN ?", clearedKeys).Delete(&ActiveAlert{}).Error; err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// StartAlertScheduler runs the alert checks in the background, once at
+// start and then on every interval
+func StartAlertScheduler(interval time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			count, err := RunAlerts()
+			if err != nil {
+				fmt.Println("Alert scheduler error:", err)
+			} else if count > 0 {
+				fmt.Println("New alerts:", count)
+			}
+
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+)
+
+const PasswordResetTokenLifespan = 24 * time.Hour
+
+type PasswordResetToken struct {
+	ID          uint       `gorm:"primary_key" json:"id"`
+	User        *User      `gorm:"foreignKey:UserId" json:"-"`
+	UserId      uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash   string     `gorm:"size:64;not null;unique" json:"-"`
+	CreatedBy   uint       `gorm:"not null" json:"created_by"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+var ErrorInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// CreatePasswordResetToken is issued by an admin for another user, the plain
+// token is only returned here and any earlier unused token stops working
+func CreatePasswordResetToken(userId uint64, createdBy uint) (string, *PasswordResetToken, error) {
+
+	var user User
+
+	if err := DB.First(&user, userId).Error; err != nil {
+		return "", nil, helper.ErrorRecordNotFound
+	}
+
+	resetToken, err := token.GenerateRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	tx := DB.Begin()
+
+	err = tx.Model(&PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		UpdateColumn("expires_at", time.Now()).Error
+	if err != nil {
+		tx.Rollback()
+		return "", nil, err
+	}
+
+	result := PasswordResetToken{
+		UserId:    user.ID,
+		TokenHash: token.HashToken(resetToken),
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(PasswordResetTokenLifespan),
+	}
+
+	if err := tx.Create(&result).Error; err != nil {
+		tx.Rollback()
+		return "", nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return "", nil, err
+	}
+
+	return resetToken, &result, nil
+}
+
+func ResetPassword(resetToken string, newPassword string) error {
+
+	var existing PasswordResetToken
+
+	err := DB.Where("token_hash = ?", token.HashToken(resetToken)).First(&existing).Error
+	if err != nil {
+		return ErrorInvalidResetToken
+	}
+
+	if existing.UsedAt != nil || time.Now().After(existing.ExpiresAt) {
+		return ErrorInvalidResetToken
+	}
+
+	if err := helper.ValidatePasswordPolicy(newPassword); err != nil {
+		return err
+	}
+
+	input := User{ID: existing.UserId, Password: newPassword}
+
+	if err := input.HashPassword(); err != nil {
+		return err
+	}
+
+	tx := DB.Begin()
+
+	result := tx.Model(&PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", existing.ID).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return ErrorInvalidResetToken
+	}
+
+	if err := tx.Model(&User{}).Where("id = ?", existing.UserId).UpdateColumn("password", input.Password).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// whoever held the old password should not keep a session
+	if err := revokeAllUserTokens(tx, existing.UserId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
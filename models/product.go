@@ -98,11 +98,15 @@ func GetProduct(id uint64) (Product, error) {
         result.ProductVariations[i].Images = transformImageURLs(result.ProductVariations[i].Images)
     }
 
+	if err := fillVariationStock(result.ProductVariations); err != nil {
+		return result, errors.New("error fetching stock")
+	}
+
 	return result, nil
 }
 
 
-func (input *Product) CreateProduct() (*Product, error) {
+func (input *Product) CreateProduct(actor Actor) (*Product, error) {
 
 	isValidId := helper.IsRecordValidByID(input.ProductCategoryId, &ProductCategory{}, DB)
 
@@ -180,10 +184,13 @@ func (input *Product) CreateProduct() (*Product, error) {
 	if err != nil {
 		return &Product{}, err
 	}
+
+	recordAudit(DB, actor, AuditCreate, "products", input.ID, nil, input)
+
 	return input, nil
 }
 
-func (input *Product) UpdateProduct(id uint64) (*Product, error) {
+func (input *Product) UpdateProduct(id uint64, actor Actor) (*Product, error) {
 
     isValidId := helper.IsRecordValidByID(input.ProductCategoryId, &ProductCategory{}, DB)
 
@@ -217,6 +224,11 @@ func (input *Product) UpdateProduct(id uint64) (*Product, error) {
 		return &Product{}, errors.New("error fetching product")
 	}
 
+	var beforeProduct Product
+	if err := DB.Preload("ProductOptions").Preload("ProductVariations").First(&beforeProduct, id).Error; err != nil {
+		return &Product{}, errors.New("error fetching product")
+	}
+
 	existingProduct.Title = input.Title
 	existingProduct.Description = input.Description
 	existingProduct.Price = input.Price
@@ -302,6 +314,11 @@ func (input *Product) UpdateProduct(id uint64) (*Product, error) {
         return nil, err
     }
 
+	var afterProduct Product
+	if err := DB.Preload("ProductOptions").Preload("ProductVariations").First(&afterProduct, id).Error; err == nil {
+		recordAudit(DB, actor, AuditUpdate, "products", afterProduct.ID, beforeProduct, afterProduct)
+	}
+
     return input, nil
 }
 
@@ -342,7 +359,7 @@ func uploadAndAppendImage(image Image) ([]Image, error) {
 	return images, nil
 }
 
-func (input *Product) DeleteProduct(id uint64) (*Product, error) {
+func (input *Product) DeleteProduct(id uint64, actor Actor) (*Product, error) {
 
 	err := DB.Model(&Product{}).Where("id = ?", id).First(&input).Error
 	if  err != nil {
@@ -353,5 +370,8 @@ func (input *Product) DeleteProduct(id uint64) (*Product, error) {
 	if err != nil {
 		return &Product{}, err
 	}
+
+	recordAudit(DB, actor, AuditDelete, "products", input.ID, input, nil)
+
 	return input, nil
 }
\ No newline at end of file
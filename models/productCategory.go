@@ -59,7 +59,7 @@ func GetProductCategory(id uint64) (ProductCategory, error) {
 	return result, nil
 }
 
-func (input *ProductCategory) CreateProductCategory() (*ProductCategory, error) {
+func (input *ProductCategory) CreateProductCategory(actor Actor) (*ProductCategory, error) {
 
     if input.ParentCategoryId != nil && !helper.IsRecordValidByID(*input.ParentCategoryId, &ProductCategory{}, DB) {
         return &ProductCategory{}, errors.New("invalid product category id")
@@ -79,10 +79,13 @@ func (input *ProductCategory) CreateProductCategory() (*ProductCategory, error)
 	if err != nil {
 		return &ProductCategory{}, err
 	}
+
+	recordAudit(DB, actor, AuditCreate, "product_categories", input.ID, nil, input)
+
 	return input, nil
 }
 
-func (input *ProductCategory) UpdateProductCategory(id uint64) (*ProductCategory, error) {
+func (input *ProductCategory) UpdateProductCategory(id uint64, actor Actor) (*ProductCategory, error) {
 
     if input.ParentCategoryId != nil && !helper.IsRecordValidByID(*input.ParentCategoryId, &ProductCategory{}, DB) {
 		return &ProductCategory{}, errors.New("invalid product category id")
@@ -90,17 +93,13 @@ func (input *ProductCategory) UpdateProductCategory(id uint64) (*ProductCategory
 
 	var count int64
 
-	err := DB.Model(&ProductCategory{}).Where("id = ?", id).Count(&count).Error
-
-	if err != nil {
-		return &ProductCategory{}, err
-	}
+	var existingCategory ProductCategory
 
-	if count <= 0 {
+	if err := DB.First(&existingCategory, id).Error; err != nil {
 		return &ProductCategory{}, helper.ErrorRecordNotFound
 	}
 
-    if err = DB.Model(&ProductCategory{}).
+    if err := DB.Model(&ProductCategory{}).
         Where("name = ? OR name_mm = ?", input.Name, input.NameMM).
         Not("id = ?", id).
         Count(&count).Error; err != nil {
@@ -111,17 +110,22 @@ func (input *ProductCategory) UpdateProductCategory(id uint64) (*ProductCategory
         return nil, errors.New("duplicate name or name mm")
     }
 
-    err = DB.Model(&input).Where("id = ?", id).
+    err := DB.Model(&input).Where("id = ?", id).
         Updates(ProductCategory{Name: input.Name, NameMM: input.NameMM,ParentCategoryId: input.ParentCategoryId}).Error
 
     if err != nil {
         return nil, err
     }
 
+	var updatedCategory ProductCategory
+	if err := DB.First(&updatedCategory, id).Error; err == nil {
+		recordAudit(DB, actor, AuditUpdate, "product_categories", updatedCategory.ID, existingCategory, updatedCategory)
+	}
+
     return input, nil
 }
 
-func (input *ProductCategory) DeleteProductCategory(id uint64) (*ProductCategory, error) {
+func (input *ProductCategory) DeleteProductCategory(id uint64, actor Actor) (*ProductCategory, error) {
 
 	err := DB.Model(&ProductCategory{}).Where("id = ?", id).First(&input).Error
 	if  err != nil {
@@ -142,5 +146,8 @@ func (input *ProductCategory) DeleteProductCategory(id uint64) (*ProductCategory
 	if err != nil {
 		return &ProductCategory{}, err
 	}
+
+	recordAudit(DB, actor, AuditDelete, "product_categories", input.ID, input, nil)
+
 	return input, nil
 }
\ No newline at end of file
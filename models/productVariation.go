@@ -11,6 +11,7 @@ type ProductVariation struct {
     Barcode         string    	`gorm:"size:100;unique" json:"barcode"  validate:"required,min=3,max=50"`
     Images      	[]Image 	`gorm:"polymorphic:Owner"`
     IsDelete 		bool 		`json:"is_delete"`
+    OnHandQty 		float64 	`gorm:"-" json:"on_hand_qty"`
     CreatedAt       time.Time   `json:"created_at"`
 	UpdatedAt       time.Time   `json:"updated_at"`
 }
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Status string
@@ -25,11 +27,13 @@ type PurchaseOrder struct {
 	OrderNo             string    				`gorm:"index;size:255;unique" json:"order_no"`
 	Supplier   			*Supplier 				`gorm:"foreignKey:SupplierId" json:"supplier"`
 	SupplierId 			uint            		`gorm:"index;not null" json:"supplier_id" validate:"required"`
+	Warehouse   		*Warehouse 				`gorm:"foreignKey:WarehouseId" json:"warehouse"`
+	WarehouseId 		*uint            		`gorm:"index" json:"warehouse_id"`
 	TotalQty   			float64   				`gorm:"type:decimal(10,2);not null;default:0.0" json:"total_qty"`
 	TotalTaxAmount   	float64   				`gorm:"type:decimal(10,2);not null;default:0.0" json:"total_tax_amount"`
 	SubTotal   			float64   				`gorm:"type:decimal(10,2);not null;default:0.0" json:"sub_total"`
 	TotalAmount   		float64   				`gorm:"type:decimal(10,2);not null;default:0.0" json:"total_amount"`
-	Status      		Status 					`gorm:"type:enum('pending', 'partial', 'complete');default:'pending'" json:"status"`
+	Status      		PurchaseOrderStatus 	`gorm:"type:enum('draft', 'approved', 'sent', 'cancelled', 'closed');default:'draft'" json:"status"`
 	ReceivedStatus      Status 					`gorm:"type:enum('pending', 'partial', 'complete');default:'pending'" json:"received_status"`
 	Description       	string    				`gorm:"type:text" json:"description"`
 	TotalItemCount      uint    				`gorm:"" json:"total_item_count"`
@@ -39,6 +43,17 @@ type PurchaseOrder struct {
 	PurchaseDate		time.Time 				`gorm:"" json:"purchase_date" validate:"required"`
 	ReferenceNo          string    				`gorm:"size:255;" json:"reference_no"`
 	NoteToSupplier       string    				`gorm:"type:text;" json:"note_to_supplier"`
+	SupplierStatus       SupplierStatus 		`gorm:"type:enum('pending', 'acknowledged', 'rejected');default:'pending'" json:"supplier_status"`
+	ExpectedDeliveryDate *time.Time 			`json:"expected_delivery_date"`
+	SupplierNote         string    				`gorm:"type:text;" json:"supplier_note"`
+	SupplierRespondedAt  *time.Time 			`json:"supplier_responded_at"`
+	CreatedBy            uint 					`gorm:"not null;default:0" json:"created_by"`
+	ApprovedBy           uint 					`gorm:"not null;default:0" json:"approved_by"`
+	ApprovedAt           *time.Time 			`json:"approved_at"`
+	SentAt               *time.Time 			`json:"sent_at"`
+	CancelledAt          *time.Time 			`json:"cancelled_at"`
+	CancelReason         string    				`gorm:"type:text;" json:"cancel_reason"`
+	ClosedAt             *time.Time 			`json:"closed_at"`
 	CreatedAt   		time.Time 				`json:"created_at"`
 	UpdatedAt   		time.Time 				`json:"updated_at"`
 	DeletedAt        	gorm.DeletedAt   		`gorm:"index"`
@@ -46,6 +61,7 @@ type PurchaseOrder struct {
 
 type UpdatePurchaseOrder struct {
 	SupplierId     		uint                   `json:"supplier_id" validate:"required"`
+	WarehouseId     	*uint                  `json:"warehouse_id"`
 	PurchaseDate	  	 time.Time 				`gorm:"" json:"purchase_date" validate:"required"`
 	Description       	 string    				`gorm:"type:text" json:"description"`
 	ReferenceNo          string    				`gorm:"size:255;" json:"reference_no"`
@@ -56,7 +72,14 @@ type UpdatePurchaseOrder struct {
 }
 
 type ReceivePurchaseOrder struct {
+	WarehouseId     	uint 						`json:"warehouse_id"`
 	ReceiveItems     	[]ReceivePurchaseOrderItem 	`json:"receive_items" validate:"required,dive,required"`
+	DeliveryNoteNo     	string 						`json:"delivery_note_no" validate:"max=255"`
+	DriverName     		string 						`json:"driver_name" validate:"max=255"`
+	VehicleNo     		string 						`json:"vehicle_no" validate:"max=100"`
+	ReceiverName     	string 						`json:"receiver_name" validate:"max=255"`
+	ReceivedAt     		*time.Time 					`json:"received_at"`
+	Note     			string 						`json:"note"`
 }
 
 func (p *PurchaseOrder) UnmarshalJSON(data []byte) error {
@@ -81,21 +104,15 @@ func (p *PurchaseOrder) UnmarshalJSON(data []byte) error {
     return nil
 }
 
-func (p *PurchaseOrder) BeforeSave(*gorm.DB) error {
-	var lastOrder PurchaseOrder
+// BeforeCreate numbers the order, only on create so later saves keep their number
+func (p *PurchaseOrder) BeforeCreate(tx *gorm.DB) error {
 
-	result := DB.Unscoped().Last(&lastOrder)
-
-	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
-		return result.Error
+	no, err := nextDocumentNo(tx, &PurchaseOrder{}, "P")
+	if err != nil {
+		return err
 	}
+	p.OrderNo = no
 
-	if lastOrder.ID != 0 {
-		p.OrderNo = fmt.Sprintf("P%05d", lastOrder.ID+1)
-	} else {
-		p.OrderNo = fmt.Sprintf("P%05d", 1)
-	}
-	
 	return nil
 }
 
@@ -124,6 +141,7 @@ func (po *PurchaseOrder) CalculateTotals() {
 		totalAmount += item.TotalAmount
 	}
 
+	po.TotalItemCount = totalItemCount
 	po.TotalQty = totalQty
 	po.SubTotal = subTotal
 	po.TotalTaxAmount = totalTaxAmount
@@ -171,6 +189,7 @@ func GetPurchaseOrder(id uint64) (PurchaseOrder, error) {
 	var result PurchaseOrder
 
 	err := DB.Preload("Supplier").
+			Preload("Warehouse").
 			Preload("PurchaseOrderItems").
 			First(&result, id).Error
 
@@ -181,7 +200,7 @@ func GetPurchaseOrder(id uint64) (PurchaseOrder, error) {
 	return result, nil
 }
 
-func (input *PurchaseOrder) CreatePurchaseOrder() (*PurchaseOrder, error) {
+func (input *PurchaseOrder) CreatePurchaseOrder(actor Actor) (*PurchaseOrder, error) {
 	
     isValidSupplierId := helper.IsRecordValidByID(input.SupplierId, &Supplier{}, DB)
 
@@ -189,6 +208,12 @@ func (input *PurchaseOrder) CreatePurchaseOrder() (*PurchaseOrder, error) {
 		return &PurchaseOrder{}, errors.New("invalid supplier id")
 	}
 
+	if input.WarehouseId != nil {
+		if _, err := getActiveWarehouse(DB, *input.WarehouseId); err != nil {
+			return &PurchaseOrder{}, err
+		}
+	}
+
 	var totalItemCount uint
 	var totalQty, subTotal, totalAmount, totalTaxAmount float64
 	var purchaseOrderItems []PurchaseOrderItem
@@ -224,31 +249,83 @@ func (input *PurchaseOrder) CreatePurchaseOrder() (*PurchaseOrder, error) {
 	}
 
 	input.PurchaseOrderItems = purchaseOrderItems
+	input.CreatedBy = actor.UserId
+	input.Status = PurchaseOrderDraft
+	input.ApprovedBy = 0
+	input.ApprovedAt = nil
+	input.SentAt = nil
+	input.CancelledAt = nil
+	input.CancelReason = ""
+	input.ClosedAt = nil
+	input.SupplierStatus = SupplierStatusPending
+	input.ExpectedDeliveryDate = nil
+	input.SupplierNote = ""
+	input.SupplierRespondedAt = nil
 	input.TotalItemCount = totalItemCount
 	input.TotalQty = totalQty
 	input.SubTotal = subTotal
 	input.TotalTaxAmount = totalTaxAmount
 	input.TotalAmount = totalAmount
 
-	err := DB.Create(&input).Error
+	tx := DB.Begin()
+
+	err := tx.Create(&input).Error
 
 	if err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+	if err := routePurchaseOrderApprovals(tx, input); err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+	recordAudit(tx, actor, AuditCreate, "purchase_orders", input.ID, nil, input)
+
+	if err := tx.Commit().Error; err != nil {
 		return &PurchaseOrder{}, err
 	}
+
 	return input, nil
 }
 
-func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64) (*PurchaseOrder, error) {
+func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
 
 	tx := DB.Begin()
 
     var existingPurchaseOrder PurchaseOrder
-	if err := tx.First(&existingPurchaseOrder, id).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
 		return &PurchaseOrder{}, errors.New("error fetching purchase order")
 	}
 
+	var beforePurchaseOrder PurchaseOrder
+	if err := tx.Preload("PurchaseOrderItems").First(&beforePurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, errors.New("error fetching purchase order")
+	}
+
+	if !existingPurchaseOrder.Status.isEditable() {
+		tx.Rollback()
+		return &PurchaseOrder{}, fmt.Errorf("a %s purchase order cannot be edited", existingPurchaseOrder.Status)
+	}
+
+	if input.WarehouseId != nil {
+		if _, err := getActiveWarehouse(tx, *input.WarehouseId); err != nil {
+			tx.Rollback()
+			return &PurchaseOrder{}, err
+		}
+	}
+
+	// an edited order has to be approved again
+	existingPurchaseOrder.Status = PurchaseOrderDraft
+	existingPurchaseOrder.ApprovedBy = 0
+	existingPurchaseOrder.ApprovedAt = nil
+
 	// Update purchase order fields with the payload
     existingPurchaseOrder.SupplierId = input.SupplierId
+    existingPurchaseOrder.WarehouseId = input.WarehouseId
     existingPurchaseOrder.PurchaseDate = input.PurchaseDate
     existingPurchaseOrder.Description = input.Description
     existingPurchaseOrder.ReferenceNo = input.ReferenceNo
@@ -257,9 +334,10 @@ func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64) (*PurchaseOrder
     // Process add_items
 
     for _, addItem := range input.AddItems {
-		isValidId := helper.IsRecordValidByID(addItem.ProductVariationId, &ProductVariation{}, DB)
+		isValidId := helper.IsRecordValidByID(addItem.ProductVariationId, &ProductVariation{}, tx)
 
 		if !isValidId {
+			tx.Rollback()
 			return &PurchaseOrder{}, errors.New("invalid product variation id")
 		}
         newItem := PurchaseOrderItem{
@@ -295,7 +373,6 @@ func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64) (*PurchaseOrder
 			tx.Rollback()
 			return &PurchaseOrder{}, err
 		}
-		existingPurchaseOrder.PurchaseOrderItems = append(existingPurchaseOrder.PurchaseOrderItems, updateItem)
     }
 
     // Process delete_items
@@ -315,15 +392,35 @@ func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64) (*PurchaseOrder
 		}
 	}
 
-    // Update total quantities and amounts
-    existingPurchaseOrder.CalculateTotals()
-
-    // Save the updated purchase order
+    // Save the updated purchase order with its added items
     if err := tx.Save(&existingPurchaseOrder).Error; err != nil {
 		tx.Rollback()
         return &PurchaseOrder{}, err
     }
 
+	// the totals and the approval routing cover every stored line, not
+	// only the lines of this request
+	existingPurchaseOrder.PurchaseOrderItems = nil
+	if err := tx.Where("purchase_order_id = ?", id).Order("id").Find(&existingPurchaseOrder.PurchaseOrderItems).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+    // Update total quantities and amounts
+    existingPurchaseOrder.CalculateTotals()
+
+	if err := tx.Omit("PurchaseOrderItems").Save(&existingPurchaseOrder).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+	if err := routePurchaseOrderApprovals(tx, &existingPurchaseOrder); err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+	recordPurchaseOrderAudit(tx, actor, beforePurchaseOrder)
+
 	if err := tx.Commit().Error; err != nil {
         return &PurchaseOrder{}, err
     }
@@ -331,17 +428,80 @@ func (input *UpdatePurchaseOrder) UpdatePurchaseOrder(id uint64) (*PurchaseOrder
     return &existingPurchaseOrder, nil
 }
 
-func (input *ReceivePurchaseOrder) ReceivePurchaseOrder(id uint64) (*PurchaseOrder, error) {
+// recordPurchaseOrderAudit compares the order and its items against the saved state
+func recordPurchaseOrderAudit(tx *gorm.DB, actor Actor, before PurchaseOrder) {
+
+	var after PurchaseOrder
+	if err := tx.Preload("PurchaseOrderItems").First(&after, before.ID).Error; err == nil {
+		recordAudit(tx, actor, AuditUpdate, "purchase_orders", after.ID, before, after)
+	}
+}
+
+func (input *ReceivePurchaseOrder) ReceivePurchaseOrder(id uint64, actor Actor) (*GoodsReceivedNote, error) {
 
 	tx := DB.Begin()
 
+	// locked so a receive and a void of one of its notes do not both
+	// move the order counters from the same values
     var existingPurchaseOrder PurchaseOrder
-	if err := tx.First(&existingPurchaseOrder, id).Error; err != nil {
-		return &PurchaseOrder{}, errors.New("error fetching purchase order")
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("error fetching purchase order")
+	}
+
+	var beforePurchaseOrder PurchaseOrder
+	if err := tx.Preload("PurchaseOrderItems").First(&beforePurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("error fetching purchase order")
 	}
 
 	if existingPurchaseOrder.ReceivedStatus == Complete && existingPurchaseOrder.TotalRemainingQty == 0 {
-		return &PurchaseOrder{}, errors.New("this purchase order is already received")
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("this purchase order is already received")
+	}
+
+	if !existingPurchaseOrder.Status.isReceivable() {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, fmt.Errorf("a %s purchase order cannot be received", existingPurchaseOrder.Status)
+	}
+
+	// goods go to the warehouse given on receive, else the one on the order
+	warehouseId := input.WarehouseId
+	if warehouseId == 0 && existingPurchaseOrder.WarehouseId != nil {
+		warehouseId = *existingPurchaseOrder.WarehouseId
+	}
+	if warehouseId == 0 {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, errors.New("please choose the receiving warehouse")
+	}
+	if _, err := getActiveWarehouse(tx, warehouseId); err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
+	}
+
+	// each receive is its own goods received note, the stock comes in
+	// under the note so it can be voided later
+	receivedAt := time.Now()
+	if input.ReceivedAt != nil {
+		receivedAt = *input.ReceivedAt
+	}
+
+	note := GoodsReceivedNote{
+		PurchaseOrderId: existingPurchaseOrder.ID,
+		WarehouseId:     warehouseId,
+		Status:          GoodsReceivedNotePosted,
+		DeliveryNoteNo:  input.DeliveryNoteNo,
+		DriverName:      input.DriverName,
+		VehicleNo:       input.VehicleNo,
+		ReceiverName:    input.ReceiverName,
+		ReceivedBy:      actor.UserId,
+		ReceivedAt:      receivedAt,
+		Note:            input.Note,
+	}
+
+	if err := tx.Create(&note).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
 	}
 
     // Process update_items
@@ -351,12 +511,17 @@ func (input *ReceivePurchaseOrder) ReceivePurchaseOrder(id uint64) (*PurchaseOrd
 
 		if err := tx.Where("ID = ? AND purchase_order_id = ?", updateItem.ID, id).First(&existingItem).Error; err !=  nil {         
 			tx.Rollback()
-			return &PurchaseOrder{}, err
+			return &GoodsReceivedNote{}, err
+		}
+
+		if updateItem.ReceivedQty <= 0 {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, errors.New("receive qty must be greater than zero")
 		}
 
 		if updateItem.ReceivedQty > existingItem.TotalRemainingQty {
 			tx.Rollback()
-			return &PurchaseOrder{}, errors.New("please enter receive qty less than remaining qty")
+			return &GoodsReceivedNote{}, errors.New("please enter receive qty less than remaining qty")
 		}
 
 		existingItem.TotalReceivedQty += updateItem.ReceivedQty
@@ -370,9 +535,62 @@ func (input *ReceivePurchaseOrder) ReceivePurchaseOrder(id uint64) (*PurchaseOrd
 		
 		if err := tx.Save(&existingItem).Error; err != nil {
 			tx.Rollback()
-			return &PurchaseOrder{}, err
+			return &GoodsReceivedNote{}, err
+		}
+
+		// without lots the whole qty is booked in as unlotted stock
+		receivedLots := []lotQty{{LotId: 0, Qty: updateItem.ReceivedQty}}
+
+		if len(updateItem.Lots) > 0 {
+			receivedLots = nil
+
+			var lotsQty float64
+			for _, receiveLot := range updateItem.Lots {
+				lot, err := findOrCreateStockLot(tx, existingItem.ProductVariationId, receiveLot)
+				if err != nil {
+					tx.Rollback()
+					return &GoodsReceivedNote{}, err
+				}
+				receivedLots = append(receivedLots, lotQty{LotId: lot.ID, Qty: receiveLot.Qty})
+				lotsQty += receiveLot.Qty
+			}
+
+			if math.Abs(lotsQty-updateItem.ReceivedQty) > 0.001 {
+				tx.Rollback()
+				return &GoodsReceivedNote{}, errors.New("lot qtys must add up to the receive qty")
+			}
 		}
 
+		source := note.stockSource()
+		for _, receivedLot := range receivedLots {
+			if err := recordStockMovement(tx, actor, source, StockMovement{
+				ProductVariationId: existingItem.ProductVariationId,
+				WarehouseId:        warehouseId,
+				LotId:              receivedLot.LotId,
+				Qty:                receivedLot.Qty,
+				Reason:             ReasonPurchaseReceipt,
+				UnitCost:           existingItem.UnitPrice,
+			}); err != nil {
+				tx.Rollback()
+				return &GoodsReceivedNote{}, err
+			}
+		}
+
+		noteItem := GoodsReceivedNoteItem{
+			GoodsReceivedNoteId: note.ID,
+			PurchaseOrderItemId: existingItem.ID,
+			ProductVariationId:  existingItem.ProductVariationId,
+			ProductName:         existingItem.ProductName,
+			Qty:                 updateItem.ReceivedQty,
+			UnitCost:            existingItem.UnitPrice,
+		}
+		if err := tx.Create(&noteItem).Error; err != nil {
+			tx.Rollback()
+			return &GoodsReceivedNote{}, err
+		}
+		note.GoodsReceivedNoteItems = append(note.GoodsReceivedNoteItems, noteItem)
+		note.TotalQty += updateItem.ReceivedQty
+
 		existingPurchaseOrder.TotalReceivedQty += updateItem.ReceivedQty
 		existingPurchaseOrder.TotalRemainingQty = existingPurchaseOrder.TotalRemainingQty - updateItem.ReceivedQty
 		
@@ -381,31 +599,55 @@ func (input *ReceivePurchaseOrder) ReceivePurchaseOrder(id uint64) (*PurchaseOrd
 	if existingPurchaseOrder.TotalRemainingQty > 0 {
 		existingPurchaseOrder.ReceivedStatus = Partial
 	}else{
+		// nothing more to come, the order is done
+		now := time.Now()
 		existingPurchaseOrder.ReceivedStatus = Complete
+		existingPurchaseOrder.Status = PurchaseOrderClosed
+		existingPurchaseOrder.ClosedAt = &now
 	}
 
     // Save the updated purchase order
     if err := tx.Save(&existingPurchaseOrder).Error; err != nil {
 		tx.Rollback()
-        return &PurchaseOrder{}, err
+        return &GoodsReceivedNote{}, err
     }
 
+	if err := tx.Model(&note).Update("total_qty", note.TotalQty).Error; err != nil {
+		tx.Rollback()
+		return &GoodsReceivedNote{}, err
+	}
+
+	recordPurchaseOrderAudit(tx, actor, beforePurchaseOrder)
+	recordAudit(tx, actor, AuditCreate, "goods_received_notes", note.ID, nil, note)
+
 	if err := tx.Commit().Error; err != nil {
-        return &PurchaseOrder{}, err
+        return &GoodsReceivedNote{}, err
     }
 
-    return &existingPurchaseOrder, nil
+    return &note, nil
 }
 
-func (input *PurchaseOrder) DeletePurchaseOrder(id uint64) (*PurchaseOrder, error) {
+func (input *PurchaseOrder) DeletePurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
     
     tx := DB.Begin()
 
-    if err := tx.First(input, id).Error; err != nil {
+    if err := tx.Preload("PurchaseOrderItems").First(input, id).Error; err != nil {
         tx.Rollback()
         return nil, helper.ErrorRecordNotFound
     }
 
+	before := *input
+
+	// anything past draft stays on record, cancel it instead
+	if input.Status != PurchaseOrderDraft && input.Status != PurchaseOrderCancelled {
+		tx.Rollback()
+		return nil, fmt.Errorf("a %s purchase order cannot be deleted", input.Status)
+	}
+	if input.TotalReceivedQty > 0 {
+		tx.Rollback()
+		return nil, errors.New("purchase order has received goods and cannot be deleted")
+	}
+
 	if err := tx.Model(&input).Association("PurchaseOrderItems").Unscoped().Clear(); err != nil {
     	tx.Rollback()
         return nil, err
@@ -416,6 +658,8 @@ func (input *PurchaseOrder) DeletePurchaseOrder(id uint64) (*PurchaseOrder, erro
         return nil, err
     }
 
+	recordAudit(tx, actor, AuditDelete, "purchase_orders", before.ID, before, nil)
+
     if err := tx.Commit().Error; err != nil {
         return nil, err
     }
@@ -0,0 +1,508 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+// PurchaseOrderApprovalRule routes a draft order to its approvers when the
+// order total falls in [MinAmount, MaxAmount) and, if set, the supplier
+// matches and an item belongs to the category. Every approver of every
+// matching rule has to approve, lower levels first
+type PurchaseOrderApprovalRule struct {
+	ID                uint                                `gorm:"primary_key" json:"id"`
+	Name              string                              `gorm:"size:255;not null" json:"name" validate:"required,max=255"`
+	Level             uint                                `gorm:"not null;default:1" json:"level" validate:"required,gte=1"`
+	MinAmount         float64                             `gorm:"type:decimal(12,2);not null;default:0.0" json:"min_amount" validate:"gte=0"`
+	MaxAmount         *float64                            `gorm:"type:decimal(12,2)" json:"max_amount" validate:"omitempty,gt=0"`
+	Supplier          *Supplier                           `gorm:"foreignKey:SupplierId" json:"supplier,omitempty"`
+	SupplierId        *uint                               `gorm:"index" json:"supplier_id"`
+	ProductCategory   *ProductCategory                    `gorm:"foreignKey:ProductCategoryId" json:"product_category,omitempty"`
+	ProductCategoryId *uint                               `gorm:"index" json:"product_category_id"`
+	IsActive          bool                                `gorm:"not null;default:true" json:"is_active"`
+	Approvers         []PurchaseOrderApprovalRuleApprover `gorm:"foreignKey:RuleId" json:"approvers" validate:"required,min=1,dive"`
+	CreatedAt         time.Time                           `json:"created_at"`
+	UpdatedAt         time.Time                           `json:"updated_at"`
+}
+
+type PurchaseOrderApprovalRuleApprover struct {
+	ID     uint `gorm:"primary_key" json:"id"`
+	RuleId uint `gorm:"index;not null" json:"rule_id"`
+	UserId uint `gorm:"not null" json:"user_id" validate:"required"`
+}
+
+// PurchaseOrderApproval is one approver's sign-off on an order, the rows
+// are routed again whenever the order is edited
+type PurchaseOrderApproval struct {
+	ID              uint           `gorm:"primary_key" json:"id"`
+	PurchaseOrder   *PurchaseOrder `gorm:"foreignKey:PurchaseOrderId" json:"purchase_order,omitempty"`
+	PurchaseOrderId uint           `gorm:"index;not null" json:"purchase_order_id"`
+	RuleId          uint           `gorm:"index;not null" json:"rule_id"`
+	RuleName        string         `gorm:"size:255" json:"rule_name"`
+	Level           uint           `gorm:"not null;default:1" json:"level"`
+	ApproverId      uint           `gorm:"index;not null" json:"approver_id"`
+	ApproverName    string         `gorm:"size:255" json:"approver_name"`
+	Status          ApprovalStatus `gorm:"type:enum('pending', 'approved', 'rejected');default:'pending';index" json:"status"`
+	Comment         string         `gorm:"type:text" json:"comment"`
+	DecidedAt       *time.Time     `json:"decided_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+type DecidePurchaseOrderApproval struct {
+	Decision ApprovalStatus `json:"decision" validate:"required,oneof=approved rejected"`
+	Comment  string         `json:"comment" validate:"max=1000"`
+}
+
+func validatePurchaseOrderApprovalRule(input *PurchaseOrderApprovalRule) error {
+
+	if input.MaxAmount != nil && *input.MaxAmount <= input.MinAmount {
+		return errors.New("max amount must be more than min amount")
+	}
+	if input.SupplierId != nil && !helper.IsRecordValidByID(*input.SupplierId, &Supplier{}, DB) {
+		return errors.New("invalid supplier id")
+	}
+	if input.ProductCategoryId != nil && !helper.IsRecordValidByID(*input.ProductCategoryId, &ProductCategory{}, DB) {
+		return errors.New("invalid product category id")
+	}
+
+	seen := make(map[uint]bool)
+	for _, approver := range input.Approvers {
+		if seen[approver.UserId] {
+			return fmt.Errorf("user %d is listed twice", approver.UserId)
+		}
+		seen[approver.UserId] = true
+
+		var user User
+		if err := DB.First(&user, approver.UserId).Error; err != nil {
+			return fmt.Errorf("invalid approver user id %d", approver.UserId)
+		}
+		if !user.IsActive {
+			return fmt.Errorf("approver %s is deactivated", user.Username)
+		}
+	}
+	return nil
+}
+
+func GetAllPurchaseOrderApprovalRules(c *gin.Context) ([]PurchaseOrderApprovalRule, error) {
+
+	var results []PurchaseOrderApprovalRule
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	supplierId := c.Query("supplier_id")
+	isActive := c.Query("is_active")
+
+	db := DB.Model(&PurchaseOrderApprovalRule{})
+
+	if supplierId != "" {
+		db = db.Where("supplier_id = ?", supplierId)
+	}
+	if isActive != "" {
+		db = db.Where("is_active = ?", isActive == "true")
+	}
+
+	if err := utils.Paginate(db.Preload("Approvers"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no approval rules")
+	}
+
+	return results, nil
+}
+
+func GetPurchaseOrderApprovalRule(id uint64) (PurchaseOrderApprovalRule, error) {
+
+	var result PurchaseOrderApprovalRule
+
+	err := DB.Preload("Approvers").
+			Preload("Supplier").
+			Preload("ProductCategory").
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *PurchaseOrderApprovalRule) CreatePurchaseOrderApprovalRule(actor Actor) (*PurchaseOrderApprovalRule, error) {
+
+	if err := validatePurchaseOrderApprovalRule(input); err != nil {
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	var approvers []PurchaseOrderApprovalRuleApprover
+	for _, approver := range input.Approvers {
+		approvers = append(approvers, PurchaseOrderApprovalRuleApprover{UserId: approver.UserId})
+	}
+	input.Approvers = approvers
+
+	if err := DB.Create(&input).Error; err != nil {
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "purchase_order_approval_rules", input.ID, nil, input)
+
+	return input, nil
+}
+
+// UpdatePurchaseOrderApprovalRule replaces the conditions and approvers,
+// orders already routed keep the approvals they were given
+func (input *PurchaseOrderApprovalRule) UpdatePurchaseOrderApprovalRule(id uint64, actor Actor) (*PurchaseOrderApprovalRule, error) {
+
+	if err := validatePurchaseOrderApprovalRule(input); err != nil {
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	tx := DB.Begin()
+
+	var existingRule PurchaseOrderApprovalRule
+	if err := tx.Preload("Approvers").First(&existingRule, id).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApprovalRule{}, helper.ErrorRecordNotFound
+	}
+
+	before := existingRule
+
+	if err := tx.Where("rule_id = ?", id).Delete(&PurchaseOrderApprovalRuleApprover{}).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	existingRule.Name = input.Name
+	existingRule.Level = input.Level
+	existingRule.MinAmount = input.MinAmount
+	existingRule.MaxAmount = input.MaxAmount
+	existingRule.SupplierId = input.SupplierId
+	existingRule.ProductCategoryId = input.ProductCategoryId
+	existingRule.IsActive = input.IsActive
+	existingRule.Approvers = nil
+
+	for _, approver := range input.Approvers {
+		existingRule.Approvers = append(existingRule.Approvers, PurchaseOrderApprovalRuleApprover{UserId: approver.UserId})
+	}
+
+	if err := tx.Save(&existingRule).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	recordAudit(tx, actor, AuditUpdate, "purchase_order_approval_rules", existingRule.ID, before, existingRule)
+
+	if err := tx.Commit().Error; err != nil {
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	return &existingRule, nil
+}
+
+func (input *PurchaseOrderApprovalRule) DeletePurchaseOrderApprovalRule(id uint64, actor Actor) (*PurchaseOrderApprovalRule, error) {
+
+	if err := DB.Preload("Approvers").First(&input, id).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	tx := DB.Begin()
+
+	if err := tx.Where("rule_id = ?", id).Delete(&PurchaseOrderApprovalRuleApprover{}).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	if err := tx.Delete(&PurchaseOrderApprovalRule{}, id).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	recordAudit(tx, actor, AuditDelete, "purchase_order_approval_rules", input.ID, input, nil)
+
+	if err := tx.Commit().Error; err != nil {
+		return &PurchaseOrderApprovalRule{}, err
+	}
+
+	return input, nil
+}
+
+// routePurchaseOrderApprovals drops the approvals of the order and asks the
+// approvers of every rule that matches it now. A user named on several
+// rules signs once, at the lowest level, and nobody signs their own order
+func routePurchaseOrderApprovals(tx *gorm.DB, po *PurchaseOrder) error {
+
+	if err := tx.Where("purchase_order_id = ?", po.ID).Delete(&PurchaseOrderApproval{}).Error; err != nil {
+		return err
+	}
+
+	var categoryIds []uint
+
+	err := tx.Model(&PurchaseOrderItem{}).
+			Joins("JOIN product_variations ON product_variations.id = purchase_order_items.product_variation_id").
+			Joins("JOIN products ON products.id = product_variations.product_id").
+			Where("purchase_order_items.purchase_order_id = ?", po.ID).
+			Distinct().
+			Pluck("products.product_category_id", &categoryIds).Error
+	if err != nil {
+		return err
+	}
+
+	var rules []PurchaseOrderApprovalRule
+
+	err = tx.Preload("Approvers").
+			Where("is_active = ?", true).
+			Where("min_amount <= ?", po.TotalAmount).
+			Where("max_amount IS NULL OR max_amount > ?", po.TotalAmount).
+			Where("supplier_id IS NULL OR supplier_id = ?", po.SupplierId).
+			Where("product_category_id IS NULL OR product_category_id IN ?", categoryIds).
+			Order("level, id").
+			Find(&rules).Error
+	if err != nil {
+		return err
+	}
+
+	routed := make(map[uint]bool)
+
+	for _, rule := range rules {
+		var approvers []User
+
+		if len(rule.Approvers) > 0 {
+			var userIds []uint
+			for _, approver := range rule.Approvers {
+				userIds = append(userIds, approver.UserId)
+			}
+			if err := tx.Where("id IN ? AND is_active = ?", userIds, true).Order("id").Find(&approvers).Error; err != nil {
+				return err
+			}
+		}
+		if len(approvers) == 0 {
+			return fmt.Errorf("approval rule %s has no active approver", rule.Name)
+		}
+
+		var ruleApprovers []User
+		for _, user := range approvers {
+			if po.CreatedBy == 0 || user.ID != po.CreatedBy {
+				ruleApprovers = append(ruleApprovers, user)
+			}
+		}
+		if len(ruleApprovers) == 0 {
+			return fmt.Errorf("approval rule %s has no approver other than the creator of the order", rule.Name)
+		}
+
+		for _, user := range ruleApprovers {
+			if routed[user.ID] {
+				continue
+			}
+			routed[user.ID] = true
+
+			approval := PurchaseOrderApproval{
+				PurchaseOrderId: po.ID,
+				RuleId:          rule.ID,
+				RuleName:        rule.Name,
+				Level:           rule.Level,
+				ApproverId:      user.ID,
+				ApproverName:    user.Name,
+				Status:          ApprovalPending,
+			}
+			if err := tx.Create(&approval).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPurchaseOrderApprovals refuses an order that still waits for an
+// approver or was rejected by one
+func checkPurchaseOrderApprovals(tx *gorm.DB, purchaseOrderId uint) error {
+
+	var rows []struct {
+		Status ApprovalStatus
+		Count  int64
+	}
+
+	err := tx.Model(&PurchaseOrderApproval{}).
+			Select("status, COUNT(*) AS count").
+			Where("purchase_order_id = ?", purchaseOrderId).
+			Group("status").
+			Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if row.Status == ApprovalRejected && row.Count > 0 {
+			return errors.New("purchase order was rejected, please edit it to route it again")
+		}
+	}
+	for _, row := range rows {
+		if row.Status == ApprovalPending && row.Count > 0 {
+			return fmt.Errorf("purchase order is waiting for %d approvals", row.Count)
+		}
+	}
+	return nil
+}
+
+func GetPurchaseOrderApprovals(purchaseOrderId uint64) ([]PurchaseOrderApproval, error) {
+
+	var results []PurchaseOrderApproval
+
+	if err := DB.First(&PurchaseOrder{}, purchaseOrderId).Error; err != nil {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	if err := DB.Where("purchase_order_id = ?", purchaseOrderId).Order("level, id").Find(&results).Error; err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
+// GetPendingPurchaseOrderApprovals lists the draft orders waiting for the
+// user's decision
+func GetPendingPurchaseOrderApprovals(c *gin.Context, userId uint) ([]PurchaseOrderApproval, error) {
+
+	var results []PurchaseOrderApproval
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+
+	db := DB.Model(&PurchaseOrderApproval{}).
+			Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_approvals.purchase_order_id AND purchase_orders.deleted_at IS NULL").
+			Where("purchase_order_approvals.approver_id = ? AND purchase_order_approvals.status = ?", userId, ApprovalPending).
+			Where("purchase_orders.status = ?", PurchaseOrderDraft).
+			Select("purchase_order_approvals.*")
+
+	if err := utils.Paginate(db.Preload("PurchaseOrder"), pageParam, perPageParam, &results, "created_at", "asc"); err != nil {
+		return results, errors.New("no pending approvals")
+	}
+
+	return results, nil
+}
+
+// DecidePurchaseOrderApproval records the actor's decision on a draft
+// order. Once the last approval is in the order moves to approved, a
+// rejection keeps it in draft until it is edited
+func (input *DecidePurchaseOrderApproval) DecidePurchaseOrderApproval(id uint64, actor Actor) (*PurchaseOrderApproval, error) {
+
+	if input.Decision == ApprovalRejected && input.Comment == "" {
+		return &PurchaseOrderApproval{}, errors.New("please give a comment when rejecting")
+	}
+
+	tx := DB.Begin()
+
+	// deciders of one order take turns, otherwise the last two could each
+	// count the other's approval as pending and neither approve the order
+	var existingPurchaseOrder PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, helper.ErrorRecordNotFound
+	}
+
+	if existingPurchaseOrder.Status != PurchaseOrderDraft {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, errors.New("purchase order is not waiting for approval")
+	}
+
+	if existingPurchaseOrder.CreatedBy != 0 && existingPurchaseOrder.CreatedBy == actor.UserId {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, errors.New("purchase order must be approved by another user")
+	}
+
+	var approval PurchaseOrderApproval
+
+	err := tx.Where("purchase_order_id = ? AND approver_id = ? AND status = ?", id, actor.UserId, ApprovalPending).
+			First(&approval).Error
+	if err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, errors.New("you have no pending approval on this purchase order")
+	}
+
+	var rejectedCount int64
+	if err := tx.Model(&PurchaseOrderApproval{}).
+		Where("purchase_order_id = ? AND status = ?", id, ApprovalRejected).
+		Count(&rejectedCount).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, err
+	}
+	if rejectedCount > 0 {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, errors.New("purchase order was rejected, please edit it to route it again")
+	}
+
+	var lowerCount int64
+	if err := tx.Model(&PurchaseOrderApproval{}).
+		Where("purchase_order_id = ? AND status = ? AND level < ?", id, ApprovalPending, approval.Level).
+		Count(&lowerCount).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, err
+	}
+	if lowerCount > 0 {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, errors.New("approvals of a lower level are not in yet")
+	}
+
+	before := approval
+
+	now := time.Now()
+	approval.Status = input.Decision
+	approval.Comment = input.Comment
+	approval.DecidedAt = &now
+
+	if err := tx.Save(&approval).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderApproval{}, err
+	}
+
+	recordAudit(tx, actor, AuditUpdate, "purchase_order_approvals", approval.ID, before, approval)
+
+	if approval.Status == ApprovalApproved {
+		var pendingCount int64
+		if err := tx.Model(&PurchaseOrderApproval{}).
+			Where("purchase_order_id = ? AND status = ?", id, ApprovalPending).
+			Count(&pendingCount).Error; err != nil {
+			tx.Rollback()
+			return &PurchaseOrderApproval{}, err
+		}
+
+		if pendingCount == 0 {
+			var beforePurchaseOrder PurchaseOrder
+			if err := tx.Preload("PurchaseOrderItems").First(&beforePurchaseOrder, id).Error; err != nil {
+				tx.Rollback()
+				return &PurchaseOrderApproval{}, err
+			}
+
+			existingPurchaseOrder.Status = PurchaseOrderApproved
+			existingPurchaseOrder.ApprovedBy = actor.UserId
+			existingPurchaseOrder.ApprovedAt = &now
+
+			if err := tx.Save(&existingPurchaseOrder).Error; err != nil {
+				tx.Rollback()
+				return &PurchaseOrderApproval{}, err
+			}
+
+			recordPurchaseOrderAudit(tx, actor, beforePurchaseOrder)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &PurchaseOrderApproval{}, err
+	}
+
+	return &approval, nil
+}
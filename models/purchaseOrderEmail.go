@@ -0,0 +1,382 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/mailer"
+	"gorm.io/gorm/clause"
+)
+
+type EmailStatus string
+
+const (
+	EmailQueued EmailStatus = "queued"
+	EmailSent   EmailStatus = "sent"
+	EmailFailed EmailStatus = "failed"
+)
+
+// purchaseOrderEmailMaxAttempts bounds the retries, the wait doubles
+// from a minute after each failed attempt
+const purchaseOrderEmailMaxAttempts = 5
+
+// PurchaseOrderEmail logs one email of an order to its supplier with the
+// subject and body as rendered when it was queued
+type PurchaseOrderEmail struct {
+	ID              uint        `gorm:"primary_key" json:"id"`
+	PurchaseOrderId uint        `gorm:"index;not null" json:"purchase_order_id"`
+	To              string      `gorm:"size:255;not null" json:"to"`
+	Subject         string      `gorm:"size:255;not null" json:"subject"`
+	Body            string      `gorm:"type:text" json:"body"`
+	Status          EmailStatus `gorm:"type:enum('queued', 'sent', 'failed');default:'queued';index" json:"status"`
+	Attempts        uint        `gorm:"not null;default:0" json:"attempts"`
+	LastError       string      `gorm:"type:text" json:"last_error"`
+	NextAttemptAt   *time.Time  `gorm:"index" json:"next_attempt_at"`
+	SentAt          *time.Time  `json:"sent_at"`
+	UserId          uint        `gorm:"not null;default:0" json:"user_id"`
+	ApiKeyId        uint        `gorm:"not null;default:0" json:"api_key_id"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// EmailPurchaseOrder goes to Supplier.Email, Message is added to the body
+type EmailPurchaseOrder struct {
+	Message string `json:"message" validate:"max=2000"`
+}
+
+type purchaseOrderEmailData struct {
+	CompanyName          string
+	SupplierName         string
+	OrderNo              string
+	PurchaseDate         string
+	ExpectedDeliveryDate string
+	TotalAmount          string
+	NoteToSupplier       string
+	Message              string
+}
+
+const defaultPurchaseOrderEmailSubject = `Purchase Order {{.OrderNo}}{{if .CompanyName}} from {{.CompanyName}}{{end}}`
+
+const defaultPurchaseOrderEmailBody = `Dear {{.SupplierName}},
+
+Please find attached our purchase order {{.OrderNo}} dated {{.PurchaseDate}} for a total of {{.TotalAmount}}.
+{{if .Message}}
+{{.Message}}
+{{end}}{{if .NoteToSupplier}}
+Note: {{.NoteToSupplier}}
+{{end}}
+Kindly acknowledge the order and confirm the delivery date.
+
+Regards,
+{{.CompanyName}}
+`
+
+var (
+	purchaseOrderMailer   mailer.Mailer
+	purchaseOrderMailerMu sync.Mutex
+
+	// purchaseOrderMailerWake lets a queued email go out before the next tick
+	purchaseOrderMailerWake = make(chan struct{}, 1)
+)
+
+// SetPurchaseOrderMailer replaces the mailer purchase orders are sent with,
+// by default it is the SMTP mailer configured in the environment
+func SetPurchaseOrderMailer(m mailer.Mailer) {
+
+	purchaseOrderMailerMu.Lock()
+	defer purchaseOrderMailerMu.Unlock()
+
+	purchaseOrderMailer = m
+}
+
+func getPurchaseOrderMailer() (mailer.Mailer, error) {
+
+	purchaseOrderMailerMu.Lock()
+	defer purchaseOrderMailerMu.Unlock()
+
+	if purchaseOrderMailer == nil {
+		smtpMailer, err := mailer.FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		purchaseOrderMailer = smtpMailer
+	}
+	return purchaseOrderMailer, nil
+}
+
+// loadPurchaseOrderEmailTemplates takes PO_MAIL_SUBJECT as the subject
+// template and the file at PO_MAIL_BODY_PATH as the body template
+func loadPurchaseOrderEmailTemplates() (*template.Template, *template.Template, error) {
+
+	subjectText := os.Getenv("PO_MAIL_SUBJECT")
+	if subjectText == "" {
+		subjectText = defaultPurchaseOrderEmailSubject
+	}
+
+	bodyText := defaultPurchaseOrderEmailBody
+	if bodyPath := os.Getenv("PO_MAIL_BODY_PATH"); bodyPath != "" {
+		content, err := os.ReadFile(bodyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading email template: %w", err)
+		}
+		bodyText = string(content)
+	}
+
+	subject, err := template.New("subject").Parse(subjectText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid email subject template: %w", err)
+	}
+
+	body, err := template.New("body").Parse(bodyText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid email body template: %w", err)
+	}
+
+	return subject, body, nil
+}
+
+func renderPurchaseOrderEmail(po PurchaseOrder, message string) (string, string, error) {
+
+	subjectTemplate, bodyTemplate, err := loadPurchaseOrderEmailTemplates()
+	if err != nil {
+		return "", "", err
+	}
+
+	data := purchaseOrderEmailData{
+		CompanyName:    loadPdfBrand().Name,
+		OrderNo:        po.OrderNo,
+		PurchaseDate:   po.PurchaseDate.Format("02 Jan 2006"),
+		TotalAmount:    formatPdfAmount(po.TotalAmount),
+		NoteToSupplier: po.NoteToSupplier,
+		Message:        message,
+	}
+	if po.Supplier != nil {
+		data.SupplierName = po.Supplier.Name
+	}
+	if po.ExpectedDeliveryDate != nil {
+		data.ExpectedDeliveryDate = po.ExpectedDeliveryDate.Format("02 Jan 2006")
+	}
+
+	var subject, body bytes.Buffer
+
+	if err := subjectTemplate.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := bodyTemplate.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	// a header cannot hold a line break
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+func purchaseOrderEmailBackoff(attempts uint) time.Duration {
+	return time.Minute << (attempts - 1)
+}
+
+// EmailPurchaseOrder queues the order PDF for the supplier, an approved
+// order is sent on the way in the same transaction. The mailer job makes
+// the attempts, the returned log is still queued
+func (input *EmailPurchaseOrder) EmailPurchaseOrder(id uint64, actor Actor) (*PurchaseOrderEmail, error) {
+
+	po, err := GetPurchaseOrder(id)
+	if err != nil {
+		return &PurchaseOrderEmail{}, err
+	}
+
+	if po.Supplier == nil || po.Supplier.Email == "" {
+		return &PurchaseOrderEmail{}, errors.New("supplier has no email address")
+	}
+
+	if _, err := getPurchaseOrderMailer(); err != nil {
+		return &PurchaseOrderEmail{}, err
+	}
+
+	subject, body, err := renderPurchaseOrderEmail(po, input.Message)
+	if err != nil {
+		return &PurchaseOrderEmail{}, err
+	}
+
+	tx := DB.Begin()
+
+	var existingPurchaseOrder PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, id).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderEmail{}, helper.ErrorRecordNotFound
+	}
+
+	switch existingPurchaseOrder.Status {
+	case PurchaseOrderApproved:
+		if _, err := movePurchaseOrder(tx, id, actor, PurchaseOrderSent, checkSendPurchaseOrder); err != nil {
+			tx.Rollback()
+			return &PurchaseOrderEmail{}, err
+		}
+	case PurchaseOrderSent:
+	default:
+		tx.Rollback()
+		return &PurchaseOrderEmail{}, fmt.Errorf("a %s purchase order cannot be emailed", existingPurchaseOrder.Status)
+	}
+
+	now := time.Now()
+
+	email := PurchaseOrderEmail{
+		PurchaseOrderId: po.ID,
+		To:              po.Supplier.Email,
+		Subject:         subject,
+		Body:            body,
+		Status:          EmailQueued,
+		NextAttemptAt:   &now,
+		UserId:          actor.UserId,
+		ApiKeyId:        actor.APIKeyId,
+	}
+
+	if err := tx.Create(&email).Error; err != nil {
+		tx.Rollback()
+		return &PurchaseOrderEmail{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &PurchaseOrderEmail{}, err
+	}
+
+	select {
+	case purchaseOrderMailerWake <- struct{}{}:
+	default:
+	}
+
+	return &email, nil
+}
+
+// attemptPurchaseOrderEmail sends a queued email with the order PDF as it
+// is now and records the outcome. Only a failure to save the log is
+// returned, a failed delivery is kept on the log for the next attempt
+func attemptPurchaseOrderEmail(email *PurchaseOrderEmail) error {
+
+	sendErr := func() error {
+		m, err := getPurchaseOrderMailer()
+		if err != nil {
+			return err
+		}
+
+		data, fileName, err := GetPurchaseOrderPdf(uint64(email.PurchaseOrderId))
+		if err != nil {
+			return err
+		}
+
+		return m.Send(mailer.Message{
+			To:      []string{email.To},
+			Subject: email.Subject,
+			Body:    email.Body,
+			Attachments: []mailer.Attachment{
+				{Name: fileName, ContentType: "application/pdf", Data: data},
+			},
+		})
+	}()
+
+	now := time.Now()
+	email.Attempts += 1
+
+	if sendErr == nil {
+		email.Status = EmailSent
+		email.SentAt = &now
+		email.NextAttemptAt = nil
+		email.LastError = ""
+	} else if email.Attempts >= purchaseOrderEmailMaxAttempts {
+		email.Status = EmailFailed
+		email.NextAttemptAt = nil
+		email.LastError = sendErr.Error()
+	} else {
+		next := now.Add(purchaseOrderEmailBackoff(email.Attempts))
+		email.NextAttemptAt = &next
+		email.LastError = sendErr.Error()
+	}
+
+	return DB.Save(email).Error
+}
+
+func GetPurchaseOrderEmails(purchaseOrderId uint64) ([]PurchaseOrderEmail, error) {
+
+	var results []PurchaseOrderEmail
+
+	if err := DB.First(&PurchaseOrder{}, purchaseOrderId).Error; err != nil {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	if err := DB.Where("purchase_order_id = ?", purchaseOrderId).Order("id desc").Find(&results).Error; err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
+// RetryPurchaseOrderEmails sends the queued emails that are due, a new one
+// is due right away. Each is claimed by moving its next attempt on first,
+// so two instances running the job do not both send it
+func RetryPurchaseOrderEmails() (int, error) {
+
+	var due []PurchaseOrderEmail
+
+	err := DB.Where("status = ? AND next_attempt_at <= ?", EmailQueued, time.Now()).
+			Order("next_attempt_at").
+			Limit(50).
+			Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+
+	for _, email := range due {
+		claimUntil := time.Now().Add(purchaseOrderEmailBackoff(email.Attempts + 1))
+
+		result := DB.Model(&PurchaseOrderEmail{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", email.ID, EmailQueued, email.NextAttemptAt).
+			Update("next_attempt_at", claimUntil)
+		if result.Error != nil {
+			return sent, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := attemptPurchaseOrderEmail(&email); err != nil {
+			return sent, err
+		}
+		if email.Status == EmailSent {
+			sent += 1
+		}
+	}
+
+	return sent, nil
+}
+
+func StartPurchaseOrderMailer(interval time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-purchaseOrderMailerWake:
+			}
+
+			count, err := RetryPurchaseOrderEmails()
+			if err != nil {
+				fmt.Println("Purchase order email error:", err)
+				continue
+			}
+			if count > 0 {
+				fmt.Println("Sent purchase order emails:", count)
+			}
+		}
+	}()
+}
@@ -34,5 +34,6 @@ type ReceivePurchaseOrderItem struct {
 	ReceivedStatus      Status 					`gorm:"type:enum('pending', 'partial', 'complete');default:'pending'" json:"received_status"`
 	TotalReceivedQty    float64    				`gorm:"" json:"total_received_qty"`
 	TotalRemainingQty   float64    				`gorm:"" json:"total_remaining_qty"`
+	Lots   				[]ReceiveLot    		`json:"lots" validate:"dive"`
 }
 
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderDraft     PurchaseOrderStatus = "draft"
+	PurchaseOrderApproved  PurchaseOrderStatus = "approved"
+	PurchaseOrderSent      PurchaseOrderStatus = "sent"
+	PurchaseOrderCancelled PurchaseOrderStatus = "cancelled"
+	PurchaseOrderClosed    PurchaseOrderStatus = "closed"
+)
+
+// purchaseOrderTransitions lists the statuses an order may move to from
+// each status, cancelled and closed are final
+var purchaseOrderTransitions = map[PurchaseOrderStatus][]PurchaseOrderStatus{
+	PurchaseOrderDraft:    {PurchaseOrderApproved, PurchaseOrderCancelled},
+	PurchaseOrderApproved: {PurchaseOrderSent, PurchaseOrderCancelled, PurchaseOrderClosed},
+	PurchaseOrderSent:     {PurchaseOrderCancelled, PurchaseOrderClosed},
+}
+
+type CancelPurchaseOrder struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+}
+
+func (status PurchaseOrderStatus) canMoveTo(to PurchaseOrderStatus) bool {
+
+	for _, allowed := range purchaseOrderTransitions[status] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isEditable tells whether lines and terms may still change, an order is
+// locked once it went to the supplier
+func (status PurchaseOrderStatus) isEditable() bool {
+	return status == PurchaseOrderDraft || status == PurchaseOrderApproved
+}
+
+// isReceivable tells whether goods can be booked in against the order
+func (status PurchaseOrderStatus) isReceivable() bool {
+	return status == PurchaseOrderApproved || status == PurchaseOrderSent
+}
+
+// migratePurchaseOrderStatus moves orders from the old receiving values of
+// status to the lifecycle ones, AutoMigrate cannot narrow the enum while
+// rows still hold them. Open orders were already with the supplier
+func migratePurchaseOrderStatus(db *gorm.DB) error {
+
+	if !db.Migrator().HasTable(&PurchaseOrder{}) {
+		return nil
+	}
+
+	var count int64
+
+	err := db.Raw("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() " +
+		"AND table_name = 'purchase_orders' AND column_name = 'status' AND column_type LIKE '%pending%'").
+		Scan(&count).Error
+	if err != nil || count == 0 {
+		return err
+	}
+
+	err = db.Exec("ALTER TABLE purchase_orders MODIFY status " +
+		"enum('pending', 'partial', 'complete', 'draft', 'approved', 'sent', 'cancelled', 'closed') DEFAULT 'draft'").Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec("UPDATE purchase_orders SET status = CASE WHEN received_status = 'complete' THEN 'closed' ELSE 'sent' END " +
+		"WHERE status IN ('pending', 'partial', 'complete')").Error
+}
+
+// transitionPurchaseOrder moves the order to status when the lifecycle
+// allows it, check can refuse the move with the loaded order
+func transitionPurchaseOrder(id uint64, actor Actor, to PurchaseOrderStatus, check func(*gorm.DB, *PurchaseOrder) error) (*PurchaseOrder, error) {
+
+	tx := DB.Begin()
+
+	po, err := movePurchaseOrder(tx, id, actor, to, check)
+	if err != nil {
+		tx.Rollback()
+		return &PurchaseOrder{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &PurchaseOrder{}, err
+	}
+
+	return po, nil
+}
+
+// movePurchaseOrder is transitionPurchaseOrder within the caller's
+// transaction, for a move that has to commit together with other rows
+func movePurchaseOrder(tx *gorm.DB, id uint64, actor Actor, to PurchaseOrderStatus, check func(*gorm.DB, *PurchaseOrder) error) (*PurchaseOrder, error) {
+
+	var existingPurchaseOrder PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingPurchaseOrder, id).Error; err != nil {
+		return &PurchaseOrder{}, helper.ErrorRecordNotFound
+	}
+
+	var beforePurchaseOrder PurchaseOrder
+	if err := tx.Preload("PurchaseOrderItems").First(&beforePurchaseOrder, id).Error; err != nil {
+		return &PurchaseOrder{}, helper.ErrorRecordNotFound
+	}
+
+	if !existingPurchaseOrder.Status.canMoveTo(to) {
+		return &PurchaseOrder{}, fmt.Errorf("a %s purchase order cannot be %s", existingPurchaseOrder.Status, to)
+	}
+
+	if check != nil {
+		if err := check(tx, &existingPurchaseOrder); err != nil {
+			return &PurchaseOrder{}, err
+		}
+	}
+
+	existingPurchaseOrder.Status = to
+
+	if err := tx.Save(&existingPurchaseOrder).Error; err != nil {
+		return &PurchaseOrder{}, err
+	}
+
+	recordPurchaseOrderAudit(tx, actor, beforePurchaseOrder)
+
+	return &existingPurchaseOrder, nil
+}
+
+// ApprovePurchaseOrder approves an order no approval rule applies to, or
+// one whose routed approvals are all in
+func ApprovePurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
+
+	return transitionPurchaseOrder(id, actor, PurchaseOrderApproved, func(tx *gorm.DB, po *PurchaseOrder) error {
+		if po.TotalItemCount == 0 {
+			return errors.New("purchase order has no items")
+		}
+
+		if err := checkPurchaseOrderApprovals(tx, po.ID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		po.ApprovedBy = actor.UserId
+		po.ApprovedAt = &now
+		return nil
+	})
+}
+
+// SendPurchaseOrder releases the order to the supplier, from here on it
+// shows in the supplier portal and can no longer be edited
+func SendPurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
+
+	return transitionPurchaseOrder(id, actor, PurchaseOrderSent, checkSendPurchaseOrder)
+}
+
+func checkSendPurchaseOrder(tx *gorm.DB, po *PurchaseOrder) error {
+
+	if err := checkPurchaseOrderApprovals(tx, po.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	po.SentAt = &now
+	return nil
+}
+
+// CancelPurchaseOrder keeps the order for the record, an order that was
+// partly received has to be closed instead
+func (input *CancelPurchaseOrder) CancelPurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
+
+	return transitionPurchaseOrder(id, actor, PurchaseOrderCancelled, func(tx *gorm.DB, po *PurchaseOrder) error {
+		if po.TotalReceivedQty > 0 {
+			return errors.New("purchase order is partly received, please close it instead")
+		}
+
+		now := time.Now()
+		po.CancelledAt = &now
+		po.CancelReason = input.Reason
+		return nil
+	})
+}
+
+// ClosePurchaseOrder ends an order that will not be delivered in full,
+// fully received orders close on their own
+func ClosePurchaseOrder(id uint64, actor Actor) (*PurchaseOrder, error) {
+
+	return transitionPurchaseOrder(id, actor, PurchaseOrderClosed, func(tx *gorm.DB, po *PurchaseOrder) error {
+		now := time.Now()
+		po.ClosedAt = &now
+		return nil
+	})
+}
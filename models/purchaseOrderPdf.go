@@ -0,0 +1,454 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// pdfBrand is the letterhead printed on documents, every deployment sets
+// its own through the environment
+type pdfBrand struct {
+	Name         string
+	Address      string
+	Phone        string
+	Email        string
+	LogoPath     string
+	FontPath     string
+	BoldFontPath string
+	Color        [3]int
+}
+
+const pdfFontFamily = "brand"
+
+func loadPdfBrand() pdfBrand {
+
+	return pdfBrand{
+		Name:         os.Getenv("COMPANY_NAME"),
+		Address:      os.Getenv("COMPANY_ADDRESS"),
+		Phone:        os.Getenv("COMPANY_PHONE"),
+		Email:        os.Getenv("COMPANY_EMAIL"),
+		LogoPath:     os.Getenv("COMPANY_LOGO_PATH"),
+		FontPath:     os.Getenv("PDF_FONT_PATH"),
+		BoldFontPath: os.Getenv("PDF_BOLD_FONT_PATH"),
+		Color:        parseHexColor(os.Getenv("PDF_BRAND_COLOR"), [3]int{31, 78, 121}),
+	}
+}
+
+// parseHexColor reads a #rrggbb color, anything else gives the fallback
+func parseHexColor(value string, fallback [3]int) [3]int {
+
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) != 6 {
+		return fallback
+	}
+
+	var color [3]int
+	for i := 0; i < 3; i++ {
+		part, err := strconv.ParseUint(value[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return fallback
+		}
+		color[i] = int(part)
+	}
+	return color
+}
+
+// withoutMyanmarScript replaces Myanmar script with ?. The PDF writer does
+// no complex script shaping, the glyphs it would place for Myanmar text
+// come out in the wrong order and unjoined, so it is not printed at all
+func withoutMyanmarScript(text string) string {
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 0x1000 && r <= 0x109F) || (r >= 0xA9E0 && r <= 0xA9FF) || (r >= 0xAA60 && r <= 0xAA7F) {
+			return '?'
+		}
+		return r
+	}, text)
+}
+
+// newBrandedPdf starts an A4 document with the brand font and page footer.
+// Without PDF_FONT_PATH it falls back to a core font, the returned tr
+// replaces what the font cannot encode and Myanmar script with either font
+func newBrandedPdf(brand pdfBrand, title string) (*gofpdf.Fpdf, string, func(string) string, error) {
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator(brand.Name, true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AliasNbPages("")
+
+	family := "Helvetica"
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	if brand.FontPath != "" {
+		boldPath := brand.BoldFontPath
+		if boldPath == "" {
+			boldPath = brand.FontPath
+		}
+
+		for style, fontPath := range map[string]string{"": brand.FontPath, "B": boldPath} {
+			font, err := os.ReadFile(fontPath)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("error reading pdf font: %w", err)
+			}
+			pdf.AddUTF8FontFromBytes(pdfFontFamily, style, font)
+		}
+
+		family = pdfFontFamily
+		tr = withoutMyanmarScript
+	}
+
+	pdf.SetFont(family, "", 9)
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont(family, "", 7)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, tr(brand.Name), "T", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	return pdf, family, tr, pdf.Error()
+}
+
+// splitPdfText wraps text already passed through tr to the width, core
+// fonts hold single byte text that SplitText cannot measure
+func splitPdfText(pdf *gofpdf.Fpdf, family string, text string, width float64) []string {
+
+	if family == pdfFontFamily {
+		return pdf.SplitText(text, width)
+	}
+
+	var lines []string
+	for _, line := range pdf.SplitLines([]byte(text), width) {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func formatPdfAmount(amount float64) string {
+
+	text := strconv.FormatFloat(amount, 'f', 2, 64)
+
+	whole, fraction := text, ""
+	if dot := strings.Index(text, "."); dot >= 0 {
+		whole, fraction = text[:dot], text[dot:]
+	}
+
+	sign := ""
+	if strings.HasPrefix(whole, "-") {
+		sign, whole = "-", whole[1:]
+	}
+
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+	grouped = append([]string{whole}, grouped...)
+
+	return sign + strings.Join(grouped, ",") + fraction
+}
+
+func formatPdfQty(qty float64) string {
+	return strconv.FormatFloat(qty, 'f', -1, 64)
+}
+
+// RenderPurchaseOrderPdf prints the order for its supplier, po needs the
+// Supplier, Warehouse and PurchaseOrderItems loaded
+func RenderPurchaseOrderPdf(po PurchaseOrder) ([]byte, error) {
+
+	brand := loadPdfBrand()
+	pdf, family, tr, err := newBrandedPdf(brand, "Purchase Order "+po.OrderNo)
+	if err != nil {
+		return nil, err
+	}
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	contentWidth := pageWidth - left - right
+
+	pdf.AddPage()
+
+	// letterhead, the logo on the left when there is one
+	textLeft := left
+	if brand.LogoPath != "" {
+		if _, err := os.Stat(brand.LogoPath); err == nil {
+			pdf.ImageOptions(brand.LogoPath, left, 15, 0, 18, false, gofpdf.ImageOptions{ReadDpi: true}, 0, "")
+			textLeft = left + 32
+		}
+	}
+
+	pdf.SetXY(textLeft, 15)
+	pdf.SetFont(family, "B", 14)
+	pdf.SetTextColor(brand.Color[0], brand.Color[1], brand.Color[2])
+	pdf.CellFormat(90, 7, tr(brand.Name), "", 2, "L", false, 0, "")
+
+	pdf.SetFont(family, "", 8)
+	pdf.SetTextColor(60, 60, 60)
+	pdf.MultiCell(90, 4, tr(brand.Address), "", "L", false)
+	for _, line := range []string{brand.Phone, brand.Email} {
+		if line != "" {
+			pdf.SetX(textLeft)
+			pdf.CellFormat(90, 4, tr(line), "", 2, "L", false, 0, "")
+		}
+	}
+	letterheadBottom := pdf.GetY()
+
+	pdf.SetXY(pageWidth-right-70, 15)
+	pdf.SetFont(family, "B", 16)
+	pdf.SetTextColor(brand.Color[0], brand.Color[1], brand.Color[2])
+	pdf.CellFormat(70, 8, "PURCHASE ORDER", "", 2, "R", false, 0, "")
+
+	pdf.SetFont(family, "", 9)
+	pdf.SetTextColor(0, 0, 0)
+
+	details := [][2]string{
+		{"Order No", po.OrderNo},
+		{"Date", po.PurchaseDate.Format("02 Jan 2006")},
+	}
+	if po.ReferenceNo != "" {
+		details = append(details, [2]string{"Reference", po.ReferenceNo})
+	}
+	if po.ExpectedDeliveryDate != nil {
+		details = append(details, [2]string{"Delivery", po.ExpectedDeliveryDate.Format("02 Jan 2006")})
+	}
+	if po.Status != PurchaseOrderSent && po.Status != PurchaseOrderClosed {
+		details = append(details, [2]string{"Status", strings.ToUpper(string(po.Status))})
+	}
+	for _, detail := range details {
+		pdf.SetX(pageWidth - right - 70)
+		pdf.CellFormat(30, 5, detail[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 5, tr(detail[1]), "", 1, "R", false, 0, "")
+	}
+
+	y := pdf.GetY()
+	if letterheadBottom > y {
+		y = letterheadBottom
+	}
+	pdf.SetDrawColor(brand.Color[0], brand.Color[1], brand.Color[2])
+	pdf.SetLineWidth(0.6)
+	pdf.Line(left, y+3, pageWidth-right, y+3)
+	pdf.SetLineWidth(0.2)
+
+	// supplier and delivery address side by side
+	blockWidth := contentWidth / 2
+	blockTop := y + 7
+
+	pdf.SetXY(left, blockTop)
+	pdf.SetFont(family, "B", 9)
+	pdf.SetTextColor(brand.Color[0], brand.Color[1], brand.Color[2])
+	pdf.CellFormat(blockWidth, 5, "SUPPLIER", "", 2, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	if po.Supplier != nil {
+		pdf.SetFont(family, "B", 10)
+		pdf.MultiCell(blockWidth-5, 5, tr(po.Supplier.Name), "", "L", false)
+		pdf.SetFont(family, "", 9)
+		pdf.MultiCell(blockWidth-5, 4.5, tr(po.Supplier.Address), "", "L", false)
+		pdf.MultiCell(blockWidth-5, 4.5, tr(strings.TrimSpace(po.Supplier.Phone+"  "+po.Supplier.Email)), "", "L", false)
+	}
+	supplierBottom := pdf.GetY()
+
+	pdf.SetXY(left+blockWidth, blockTop)
+	pdf.SetFont(family, "B", 9)
+	pdf.SetTextColor(brand.Color[0], brand.Color[1], brand.Color[2])
+	pdf.CellFormat(blockWidth, 5, "DELIVER TO", "", 2, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	if po.Warehouse != nil {
+		pdf.SetFont(family, "B", 10)
+		pdf.MultiCell(blockWidth, 5, tr(po.Warehouse.Name), "", "L", false)
+		pdf.SetX(left + blockWidth)
+		pdf.SetFont(family, "", 9)
+		pdf.MultiCell(blockWidth, 4.5, tr(po.Warehouse.Address), "", "L", false)
+		if po.Warehouse.Phone != "" {
+			pdf.SetX(left + blockWidth)
+			pdf.MultiCell(blockWidth, 4.5, tr(po.Warehouse.Phone), "", "L", false)
+		}
+	} else {
+		pdf.SetFont(family, "", 9)
+		pdf.MultiCell(blockWidth, 4.5, tr(brand.Address), "", "L", false)
+	}
+	if pdf.GetY() < supplierBottom {
+		pdf.SetY(supplierBottom)
+	}
+	pdf.Ln(6)
+
+	// item lines, the product column wraps long names
+	columns := []struct {
+		Title string
+		Width float64
+		Align string
+	}{
+		{"#", 8, "C"},
+		{"Product", 58, "L"},
+		{"Supplier SKU", 24, "L"},
+		{"Qty", 16, "R"},
+		{"Unit Price", 22, "R"},
+		{"Tax %", 12, "R"},
+		{"Tax", 20, "R"},
+		{"Amount", 20, "R"},
+	}
+
+	header := func() {
+		pdf.SetFont(family, "B", 8)
+		pdf.SetFillColor(brand.Color[0], brand.Color[1], brand.Color[2])
+		pdf.SetTextColor(255, 255, 255)
+		for _, column := range columns {
+			pdf.CellFormat(column.Width, 7, column.Title, "", 0, column.Align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont(family, "", 8.5)
+		pdf.SetTextColor(0, 0, 0)
+	}
+	header()
+
+	lineHeight := 4.5
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+
+	for i, item := range po.PurchaseOrderItems {
+		name := tr(item.ProductName)
+		lines := splitPdfText(pdf, family, name, columns[1].Width)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		rowHeight := float64(len(lines))*lineHeight + 2
+
+		if pdf.GetY()+rowHeight > pageHeight-bottom {
+			pdf.AddPage()
+			header()
+		}
+
+		taxPercent := ""
+		if item.TaxPercent != nil {
+			taxPercent = formatPdfQty(*item.TaxPercent)
+		}
+
+		values := []string{
+			strconv.Itoa(i + 1),
+			"",
+			tr(item.SupplierSKU),
+			formatPdfQty(item.Qty),
+			formatPdfAmount(item.UnitPrice),
+			taxPercent,
+			formatPdfAmount(item.TaxAmount),
+			formatPdfAmount(item.TotalAmount),
+		}
+
+		fill := i%2 == 1
+		pdf.SetFillColor(244, 246, 248)
+
+		rowTop := pdf.GetY()
+		x := left
+		for c, column := range columns {
+			pdf.SetXY(x, rowTop)
+			if c == 1 {
+				pdf.CellFormat(column.Width, rowHeight, "", "", 0, "L", fill, 0, "")
+				for l, line := range lines {
+					pdf.SetXY(x, rowTop+1+float64(l)*lineHeight)
+					pdf.CellFormat(column.Width, lineHeight, line, "", 0, "L", false, 0, "")
+				}
+			} else {
+				pdf.CellFormat(column.Width, rowHeight, values[c], "", 0, column.Align, fill, 0, "")
+			}
+			x += column.Width
+		}
+		pdf.SetXY(left, rowTop+rowHeight)
+	}
+
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(left, pdf.GetY(), pageWidth-right, pdf.GetY())
+	pdf.Ln(3)
+
+	// totals on the right
+	totals := [][2]string{
+		{"Sub Total", formatPdfAmount(po.SubTotal)},
+		{"Tax", formatPdfAmount(po.TotalTaxAmount)},
+		{"Total", formatPdfAmount(po.TotalAmount)},
+	}
+	if pdf.GetY()+float64(len(totals))*6 > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	for i, total := range totals {
+		pdf.SetX(pageWidth - right - 70)
+		if i == len(totals)-1 {
+			pdf.SetFont(family, "B", 10)
+			pdf.SetFillColor(brand.Color[0], brand.Color[1], brand.Color[2])
+			pdf.SetTextColor(255, 255, 255)
+			pdf.CellFormat(35, 7, total[0], "", 0, "L", true, 0, "")
+			pdf.CellFormat(35, 7, total[1], "", 1, "R", true, 0, "")
+			pdf.SetTextColor(0, 0, 0)
+		} else {
+			pdf.SetFont(family, "", 9)
+			pdf.CellFormat(35, 6, total[0], "", 0, "L", false, 0, "")
+			pdf.CellFormat(35, 6, total[1], "", 1, "R", false, 0, "")
+		}
+	}
+
+	if po.NoteToSupplier != "" {
+		pdf.Ln(6)
+		pdf.SetFont(family, "B", 9)
+		pdf.SetTextColor(brand.Color[0], brand.Color[1], brand.Color[2])
+		pdf.CellFormat(contentWidth, 5, "NOTE TO SUPPLIER", "", 1, "L", false, 0, "")
+		pdf.SetFont(family, "", 9)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.MultiCell(contentWidth, 4.5, tr(po.NoteToSupplier), "", "L", false)
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont(family, "", 7)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.CellFormat(contentWidth, 4, "Generated "+time.Now().Format("02 Jan 2006 15:04"), "", 1, "L", false, 0, "")
+
+	var buffer bytes.Buffer
+	if err := pdf.Output(&buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func GetPurchaseOrderPdf(id uint64) ([]byte, string, error) {
+
+	po, err := GetPurchaseOrder(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := RenderPurchaseOrderPdf(po)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, po.OrderNo + ".pdf", nil
+}
+
+// GetSupplierPurchaseOrderPdf prints an order the supplier can see in the
+// portal
+func GetSupplierPurchaseOrderPdf(id uint64, supplierId uint) ([]byte, string, error) {
+
+	po, err := GetSupplierPurchaseOrder(id, supplierId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var supplier Supplier
+	if err := DB.First(&supplier, supplierId).Error; err != nil {
+		return nil, "", err
+	}
+	po.Supplier = &supplier
+
+	data, err := RenderPurchaseOrderPdf(po)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, po.OrderNo + ".pdf", nil
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is one link of a rotation chain, every refresh marks the
+// presented token used and issues the next one on the same device
+type RefreshToken struct {
+	ID          uint       `gorm:"primary_key" json:"id"`
+	User        *User      `gorm:"foreignKey:UserId" json:"-"`
+	UserId      uint       `gorm:"index;not null" json:"user_id"`
+	DeviceId    string     `gorm:"size:64;index;not null" json:"device_id"`
+	DeviceName  string     `gorm:"size:255" json:"device_name"`
+	TokenHash   string     `gorm:"size:64;not null;unique" json:"-"`
+	ExpiresAt   time.Time  `gorm:"index;not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"-"`
+	RevokedAt   *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// AuthTokens is a signed in session, or only a challenge when the user
+// still has to pass two factor authentication
+type AuthTokens struct {
+	Token             string `json:"token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	DeviceId          string `json:"device_id,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+var ErrorInvalidRefreshToken = errors.New("invalid refresh token")
+
+func issueAuthTokens(tx *gorm.DB, user User, deviceId string, deviceName string) (*AuthTokens, error) {
+
+	if deviceId == "" {
+		id, err := token.GenerateDeviceID()
+		if err != nil {
+			return nil, err
+		}
+		deviceId = id
+	}
+
+	accessToken, err := token.GenerateToken(user.ID, user.Username, string(user.Role), deviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, expiresAt, err := token.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Create(&RefreshToken{
+		UserId:     user.ID,
+		DeviceId:   deviceId,
+		DeviceName: deviceName,
+		TokenHash:  token.HashToken(refreshToken),
+		ExpiresAt:  expiresAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthTokens{Token: accessToken, RefreshToken: refreshToken, DeviceId: deviceId}, nil
+}
+
+// StartDeviceSession signs the user in on a device, any earlier session
+// of the same device is revoked so a device only holds one chain
+func StartDeviceSession(user User, deviceId string, deviceName string) (*AuthTokens, error) {
+
+	tx := DB.Begin()
+
+	if deviceId != "" {
+		if err := revokeDeviceTokens(tx, user.ID, deviceId); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	result, err := issueAuthTokens(tx, user, deviceId, deviceName)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func RefreshAuthTokens(refreshToken string) (*AuthTokens, error) {
+
+	var existing RefreshToken
+
+	err := DB.Where("token_hash = ?", token.HashToken(refreshToken)).First(&existing).Error
+	if err != nil {
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	// a used or revoked token coming back means it leaked, kill the device chain
+	if existing.UsedAt != nil || existing.RevokedAt != nil {
+		if err := revokeDeviceTokens(DB, existing.UserId, existing.DeviceId); err != nil {
+			return nil, err
+		}
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	if time.Now().After(existing.ExpiresAt) {
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	var user User
+
+	if err := DB.First(&user, existing.UserId).Error; err != nil {
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	if !user.IsActive {
+		return nil, ErrorUserInactive
+	}
+
+	tx := DB.Begin()
+
+	// guard against two concurrent refreshes with the same token
+	result := tx.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", existing.ID).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	tokens, err := issueAuthTokens(tx, user, existing.DeviceId, existing.DeviceName)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func revokeDeviceTokens(tx *gorm.DB, userId uint, deviceId string) error {
+
+	return tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", userId, deviceId).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func revokeUserRefreshTokens(tx *gorm.DB, userId uint) error {
+
+	return tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func GetUserDevices(userId uint) ([]RefreshToken, error) {
+
+	var results []RefreshToken
+
+	err := DB.Where("user_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Order("created_at desc").
+		Find(&results).Error
+
+	return results, err
+}
+
+func RevokeUserDevice(userId uint, deviceId string) error {
+
+	var count int64
+
+	err := DB.Model(&RefreshToken{}).Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", userId, deviceId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		return helper.ErrorRecordNotFound
+	}
+
+	return revokeDeviceTokens(DB, userId, deviceId)
+}
@@ -0,0 +1,469 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+)
+
+// ReorderPoint says when a variation needs buying for a warehouse: once
+// on hand plus on order drops below MinQty, order up to MaxQty but at
+// least ReorderQty
+type ReorderPoint struct {
+	ID                 uint              `gorm:"primary_key" json:"id"`
+	ProductVariation   *ProductVariation `gorm:"foreignKey:ProductVariationId" json:"product_variation"`
+	ProductVariationId uint              `gorm:"uniqueIndex:idx_reorder_point_location;not null" json:"product_variation_id" validate:"required"`
+	Warehouse          *Warehouse        `gorm:"foreignKey:WarehouseId" json:"warehouse"`
+	WarehouseId        uint              `gorm:"uniqueIndex:idx_reorder_point_location;not null" json:"warehouse_id" validate:"required"`
+	MinQty             float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"min_qty" validate:"gte=0"`
+	MaxQty             float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"max_qty" validate:"gte=0"`
+	ReorderQty         float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"reorder_qty" validate:"gte=0"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+type PurchaseOrderSuggestion struct {
+	SupplierId    uint                          `json:"supplier_id"`
+	SupplierName  string                        `json:"supplier_name"`
+	WarehouseId   uint                          `json:"warehouse_id"`
+	WarehouseName string                        `json:"warehouse_name"`
+	TotalAmount   float64                       `json:"total_amount"`
+	Items         []PurchaseOrderSuggestionItem `json:"items"`
+}
+
+type PurchaseOrderSuggestionItem struct {
+	ProductVariationId uint    `json:"product_variation_id"`
+	ProductName        string  `json:"product_name"`
+	SKU                string  `json:"sku"`
+	SupplierSKU        string  `json:"supplier_sku"`
+	OnHandQty          float64 `json:"on_hand_qty"`
+	OnOrderQty         float64 `json:"on_order_qty"`
+	MinQty             float64 `json:"min_qty"`
+	MaxQty             float64 `json:"max_qty"`
+	ReorderQty         float64 `json:"reorder_qty"`
+	SuggestedQty       float64 `json:"suggested_qty"`
+	UnitPrice          float64 `json:"unit_price"`
+	TotalAmount        float64 `json:"total_amount"`
+}
+
+// ConvertPurchaseOrderSuggestion picks one supplier and warehouse group,
+// Items can change the qty of a line or leave lines out
+type ConvertPurchaseOrderSuggestion struct {
+	SupplierId  uint                                 `json:"supplier_id" validate:"required"`
+	WarehouseId uint                                 `json:"warehouse_id" validate:"required"`
+	Description string                               `json:"description"`
+	Items       []ConvertPurchaseOrderSuggestionItem `json:"items" validate:"dive"`
+}
+
+type ConvertPurchaseOrderSuggestionItem struct {
+	ProductVariationId uint    `json:"product_variation_id" validate:"required"`
+	Qty                float64 `json:"qty" validate:"gte=0"`
+}
+
+func validateReorderPoint(input *ReorderPoint) error {
+
+	if !helper.IsRecordValidByID(input.ProductVariationId, &ProductVariation{}, DB) {
+		return errors.New("invalid product variation id")
+	}
+	if _, err := getActiveWarehouse(DB, input.WarehouseId); err != nil {
+		return err
+	}
+	if input.MaxQty > 0 && input.MaxQty < input.MinQty {
+		return errors.New("max qty cannot be less than min qty")
+	}
+	if input.MaxQty == 0 && input.ReorderQty == 0 {
+		return errors.New("please enter max qty or reorder qty")
+	}
+	return nil
+}
+
+func GetAllReorderPoints(c *gin.Context) ([]ReorderPoint, error) {
+
+	var results []ReorderPoint
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	warehouseId := c.Query("warehouse_id")
+	variationId := c.Query("product_variation_id")
+
+	db := DB.Model(&ReorderPoint{})
+
+	if warehouseId != "" {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+	if variationId != "" {
+		db = db.Where("product_variation_id = ?", variationId)
+	}
+
+	if err := utils.Paginate(db.Preload("ProductVariation").Preload("Warehouse"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no reorder points")
+	}
+
+	return results, nil
+}
+
+func GetReorderPoint(id uint64) (ReorderPoint, error) {
+
+	var result ReorderPoint
+
+	if err := DB.Preload("ProductVariation").Preload("Warehouse").First(&result, id).Error; err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *ReorderPoint) CreateReorderPoint(actor Actor) (*ReorderPoint, error) {
+
+	if err := validateReorderPoint(input); err != nil {
+		return &ReorderPoint{}, err
+	}
+
+	var count int64
+
+	err := DB.Model(&ReorderPoint{}).
+			Where("product_variation_id = ? AND warehouse_id = ?", input.ProductVariationId, input.WarehouseId).
+			Count(&count).Error
+	if err != nil {
+		return &ReorderPoint{}, err
+	}
+	if count > 0 {
+		return &ReorderPoint{}, errors.New("reorder point already exists for this warehouse")
+	}
+
+	if err := DB.Create(&input).Error; err != nil {
+		return &ReorderPoint{}, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "reorder_points", input.ID, nil, input)
+
+	return input, nil
+}
+
+// UpdateReorderPoint changes the quantities, the variation and warehouse
+// of a reorder point stay as they are
+func (input *ReorderPoint) UpdateReorderPoint(id uint64, actor Actor) (*ReorderPoint, error) {
+
+	var existingReorderPoint ReorderPoint
+
+	if err := DB.First(&existingReorderPoint, id).Error; err != nil {
+		return &ReorderPoint{}, helper.ErrorRecordNotFound
+	}
+
+	before := existingReorderPoint
+
+	input.ProductVariationId = existingReorderPoint.ProductVariationId
+	input.WarehouseId = existingReorderPoint.WarehouseId
+
+	if err := validateReorderPoint(input); err != nil {
+		return &ReorderPoint{}, err
+	}
+
+	existingReorderPoint.MinQty = input.MinQty
+	existingReorderPoint.MaxQty = input.MaxQty
+	existingReorderPoint.ReorderQty = input.ReorderQty
+
+	if err := DB.Save(&existingReorderPoint).Error; err != nil {
+		return &ReorderPoint{}, err
+	}
+
+	recordAudit(DB, actor, AuditUpdate, "reorder_points", existingReorderPoint.ID, before, existingReorderPoint)
+
+	return &existingReorderPoint, nil
+}
+
+func (input *ReorderPoint) DeleteReorderPoint(id uint64, actor Actor) (*ReorderPoint, error) {
+
+	if err := DB.First(&input, id).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if err := DB.Delete(&input).Error; err != nil {
+		return &ReorderPoint{}, err
+	}
+
+	recordAudit(DB, actor, AuditDelete, "reorder_points", input.ID, input, nil)
+
+	return input, nil
+}
+
+// suggestedQty orders up to MaxQty, never less than ReorderQty
+func (point *ReorderPoint) suggestedQty(projectedQty float64) float64 {
+
+	qty := point.ReorderQty
+	if point.MaxQty-projectedQty > qty {
+		qty = point.MaxQty - projectedQty
+	}
+	return qty
+}
+
+// getOnOrderQtys is what open purchase orders still have to deliver into
+// a warehouse per variation, drafts count so a suggestion is not made twice
+func getOnOrderQtys(warehouseId uint) (map[uint]float64, error) {
+
+	results := make(map[uint]float64)
+
+	var rows []struct {
+		ProductVariationId uint
+		Qty                float64
+	}
+
+	err := DB.Table("purchase_order_items").
+			Select("purchase_order_items.product_variation_id, SUM(purchase_order_items.total_remaining_qty) AS qty").
+			Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_items.purchase_order_id").
+			Where("purchase_orders.warehouse_id = ? AND purchase_orders.received_status <> ?", warehouseId, Complete).
+			Where("purchase_orders.status IN ?", []PurchaseOrderStatus{PurchaseOrderDraft, PurchaseOrderApproved, PurchaseOrderSent}).
+			Where("purchase_orders.deleted_at IS NULL AND purchase_order_items.deleted_at IS NULL").
+			Group("purchase_order_items.product_variation_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		results[row.ProductVariationId] = row.Qty
+	}
+	return results, nil
+}
+
+// getLastPurchasePrice is the price and supplier SKU of the latest order
+// line for the variation, from this supplier if it was ever bought there
+func getLastPurchasePrice(variationId uint, supplierId uint) (float64, string, error) {
+
+	var last struct {
+		UnitPrice   float64
+		SupplierSku string
+	}
+
+	query := func(sameSupplier bool) error {
+		db := DB.Table("purchase_order_items").
+				Select("purchase_order_items.unit_price, purchase_order_items.supplier_sku").
+				Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_items.purchase_order_id").
+				Where("purchase_order_items.product_variation_id = ? AND purchase_order_items.deleted_at IS NULL", variationId).
+				Where("purchase_orders.deleted_at IS NULL")
+		if sameSupplier {
+			db = db.Where("purchase_orders.supplier_id = ?", supplierId)
+		}
+		return db.Order("purchase_orders.purchase_date DESC, purchase_order_items.id DESC").Limit(1).Scan(&last).Error
+	}
+
+	if err := query(true); err != nil {
+		return 0, "", err
+	}
+	if last.UnitPrice == 0 {
+		if err := query(false); err != nil {
+			return 0, "", err
+		}
+	}
+	if last.UnitPrice != 0 {
+		return last.UnitPrice, last.SupplierSku, nil
+	}
+
+	unitCost, err := getVariationUnitCost(DB, variationId)
+	return unitCost, "", err
+}
+
+// getPurchaseOrderSuggestions lists what is below minimum, grouped by the
+// product's supplier and the warehouse it is needed in
+func getPurchaseOrderSuggestions(warehouseId uint, supplierId uint) ([]PurchaseOrderSuggestion, error) {
+
+	results := []PurchaseOrderSuggestion{}
+
+	var points []ReorderPoint
+
+	db := DB.Preload("Warehouse").Preload("ProductVariation")
+	if warehouseId != 0 {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+	if err := db.Find(&points).Error; err != nil {
+		return results, err
+	}
+
+	groups := make(map[string]*PurchaseOrderSuggestion)
+	onOrderByWarehouse := make(map[uint]map[uint]float64)
+
+	for _, point := range points {
+		if point.ProductVariation == nil || point.Warehouse == nil {
+			continue
+		}
+
+		var product Product
+		if err := DB.Preload("Supplier").First(&product, point.ProductVariation.ProductId).Error; err != nil {
+			continue
+		}
+		if !product.IsQtyTracked || (supplierId != 0 && product.SupplierId != supplierId) {
+			continue
+		}
+
+		onOrderQtys, ok := onOrderByWarehouse[point.WarehouseId]
+		if !ok {
+			var err error
+			if onOrderQtys, err = getOnOrderQtys(point.WarehouseId); err != nil {
+				return results, err
+			}
+			onOrderByWarehouse[point.WarehouseId] = onOrderQtys
+		}
+
+		onHandQty, err := getWarehouseOnHandQty(DB, point.ProductVariationId, point.WarehouseId)
+		if err != nil {
+			return results, err
+		}
+		onOrderQty := onOrderQtys[point.ProductVariationId]
+
+		if onHandQty+onOrderQty >= point.MinQty {
+			continue
+		}
+
+		qty := point.suggestedQty(onHandQty + onOrderQty)
+		if qty <= 0 {
+			continue
+		}
+
+		unitPrice, supplierSKU, err := getLastPurchasePrice(point.ProductVariationId, product.SupplierId)
+		if err != nil {
+			return results, err
+		}
+
+		key := fmt.Sprintf("%d-%d", product.SupplierId, point.WarehouseId)
+		group, ok := groups[key]
+		if !ok {
+			group = &PurchaseOrderSuggestion{
+				SupplierId:    product.SupplierId,
+				WarehouseId:   point.WarehouseId,
+				WarehouseName: point.Warehouse.Name,
+				Items:         []PurchaseOrderSuggestionItem{},
+			}
+			if product.Supplier != nil {
+				group.SupplierName = product.Supplier.Name
+			}
+			groups[key] = group
+		}
+
+		productName := product.Title
+		if point.ProductVariation.VariantName != "" {
+			productName = fmt.Sprintf("%s - %s", product.Title, point.ProductVariation.VariantName)
+		}
+
+		group.Items = append(group.Items, PurchaseOrderSuggestionItem{
+			ProductVariationId: point.ProductVariationId,
+			ProductName:        productName,
+			SKU:                point.ProductVariation.SKU,
+			SupplierSKU:        supplierSKU,
+			OnHandQty:          onHandQty,
+			OnOrderQty:         onOrderQty,
+			MinQty:             point.MinQty,
+			MaxQty:             point.MaxQty,
+			ReorderQty:         point.ReorderQty,
+			SuggestedQty:       qty,
+			UnitPrice:          unitPrice,
+			TotalAmount:        qty * unitPrice,
+		})
+		group.TotalAmount += qty * unitPrice
+	}
+
+	for _, group := range groups {
+		results = append(results, *group)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].SupplierName != results[j].SupplierName {
+			return results[i].SupplierName < results[j].SupplierName
+		}
+		return results[i].WarehouseId < results[j].WarehouseId
+	})
+
+	return results, nil
+}
+
+func GetPurchaseOrderSuggestions(c *gin.Context) ([]PurchaseOrderSuggestion, error) {
+
+	var warehouseId, supplierId uint64
+
+	if param := c.Query("warehouse_id"); param != "" {
+		var err error
+		if warehouseId, err = strconv.ParseUint(param, 10, 64); err != nil {
+			return []PurchaseOrderSuggestion{}, errors.New("invalid warehouse_id")
+		}
+	}
+	if param := c.Query("supplier_id"); param != "" {
+		var err error
+		if supplierId, err = strconv.ParseUint(param, 10, 64); err != nil {
+			return []PurchaseOrderSuggestion{}, errors.New("invalid supplier_id")
+		}
+	}
+
+	results, err := getPurchaseOrderSuggestions(uint(warehouseId), uint(supplierId))
+	if err != nil {
+		return results, errors.New("error fetching suggestions")
+	}
+	return results, nil
+}
+
+// ConvertPurchaseOrderSuggestion creates the purchase order for one
+// suggestion group through CreatePurchaseOrder, at the last purchase prices
+func (input *ConvertPurchaseOrderSuggestion) ConvertPurchaseOrderSuggestion(actor Actor) (*PurchaseOrder, error) {
+
+	suggestions, err := getPurchaseOrderSuggestions(input.WarehouseId, input.SupplierId)
+	if err != nil {
+		return &PurchaseOrder{}, err
+	}
+	if len(suggestions) == 0 {
+		return &PurchaseOrder{}, errors.New("nothing to order for this supplier and warehouse")
+	}
+
+	suggestion := suggestions[0]
+
+	// without items every suggested line is ordered at the suggested qty
+	qtys := make(map[uint]float64)
+	for _, item := range suggestion.Items {
+		qtys[item.ProductVariationId] = item.SuggestedQty
+	}
+	if len(input.Items) > 0 {
+		picked := make(map[uint]float64)
+		for _, item := range input.Items {
+			if _, ok := qtys[item.ProductVariationId]; !ok {
+				return &PurchaseOrder{}, fmt.Errorf("product variation %d is not in the suggestion", item.ProductVariationId)
+			}
+			picked[item.ProductVariationId] = item.Qty
+		}
+		qtys = picked
+	}
+
+	warehouseId := suggestion.WarehouseId
+	purchaseOrder := PurchaseOrder{
+		SupplierId:   suggestion.SupplierId,
+		WarehouseId:  &warehouseId,
+		PurchaseDate: time.Now(),
+		Description:  input.Description,
+	}
+	if purchaseOrder.Description == "" {
+		purchaseOrder.Description = "created from reorder suggestions"
+	}
+
+	for _, item := range suggestion.Items {
+		qty := qtys[item.ProductVariationId]
+		if qty <= 0 {
+			continue
+		}
+		purchaseOrder.PurchaseOrderItems = append(purchaseOrder.PurchaseOrderItems, PurchaseOrderItem{
+			ProductVariationId: item.ProductVariationId,
+			ProductName:        item.ProductName,
+			SupplierSKU:        item.SupplierSKU,
+			Qty:                qty,
+			UnitPrice:          item.UnitPrice,
+		})
+	}
+
+	if len(purchaseOrder.PurchaseOrderItems) == 0 {
+		return &PurchaseOrder{}, errors.New("please choose at least one item to order")
+	}
+
+	return purchaseOrder.CreatePurchaseOrder(actor)
+}
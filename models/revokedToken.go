@@ -0,0 +1,177 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"gorm.io/gorm"
+)
+
+type RevokedToken struct {
+	ID          uint      `gorm:"primary_key" json:"id"`
+	Jti         string    `gorm:"size:64;not null;unique" json:"jti"`
+	UserId      uint      `gorm:"index;not null" json:"user_id"`
+	SupplierId  uint      `gorm:"index;not null;default:0" json:"supplier_id"`
+	ExpiresAt   time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func RevokeToken(jti string, userId uint, expiresAt time.Time) error {
+
+	return revokeToken(RevokedToken{Jti: jti, UserId: userId, ExpiresAt: expiresAt})
+}
+
+func RevokeSupplierToken(jti string, supplierId uint, expiresAt time.Time) error {
+
+	return revokeToken(RevokedToken{Jti: jti, SupplierId: supplierId, ExpiresAt: expiresAt})
+}
+
+func revokeToken(input RevokedToken) error {
+
+	if input.Jti == "" {
+		return fmt.Errorf("token has no jti")
+	}
+
+	revoked, err := isJtiRevoked(input.Jti)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return nil
+	}
+
+	return DB.Create(&input).Error
+}
+
+func isJtiRevoked(jti string) (bool, error) {
+
+	var count int64
+
+	err := DB.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return true, err
+	}
+
+	return count > 0, nil
+}
+
+// IsTokenRevoked reports whether the token was logged out by itself, was
+// issued before its user logged out everywhere or its user was deactivated
+func IsTokenRevoked(jti string, userId uint, issuedAt time.Time) (bool, error) {
+
+	if jti != "" {
+		revoked, err := isJtiRevoked(jti)
+		if err != nil || revoked {
+			return true, err
+		}
+	}
+
+	var user User
+
+	if err := DB.Select("id", "is_active", "tokens_revoked_at").First(&user, userId).Error; err != nil {
+		return true, helper.ErrorRecordNotFound
+	}
+
+	if !user.IsActive {
+		return true, nil
+	}
+
+	// iat only carries whole seconds, a login in the same second as the
+	// logout everywhere must not count as issued before it
+	if user.TokensRevokedAt != nil && issuedAt.Before(user.TokensRevokedAt.Truncate(time.Second)) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// IsSupplierTokenRevoked reports whether the token was logged out, or its
+// supplier was deleted or lost portal access
+func IsSupplierTokenRevoked(jti string, supplierId uint) (bool, error) {
+
+	if jti != "" {
+		revoked, err := isJtiRevoked(jti)
+		if err != nil || revoked {
+			return true, err
+		}
+	}
+
+	var supplier Supplier
+
+	if err := DB.Select("id", "password").First(&supplier, supplierId).Error; err != nil {
+		return true, helper.ErrorRecordNotFound
+	}
+
+	if supplier.Password == "" {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func RevokeAllUserTokens(userId uint) error {
+
+	var count int64
+
+	err := DB.Model(&User{}).Where("id = ?", userId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		return helper.ErrorRecordNotFound
+	}
+
+	tx := DB.Begin()
+
+	if err := revokeAllUserTokens(tx, userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// revokeAllUserTokens ends every access and refresh token of the user
+// inside the caller's transaction
+func revokeAllUserTokens(tx *gorm.DB, userId uint) error {
+
+	if err := tx.Model(&User{}).Where("id = ?", userId).UpdateColumn("tokens_revoked_at", time.Now().Truncate(time.Second)).Error; err != nil {
+		return err
+	}
+
+	return revokeUserRefreshTokens(tx, userId)
+}
+
+func PruneExpiredTokens() (int64, error) {
+
+	result := DB.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	refreshResult := DB.Where("expires_at < ?", time.Now()).Delete(&RefreshToken{})
+
+	return result.RowsAffected + refreshResult.RowsAffected, refreshResult.Error
+}
+
+// StartTokenPruner removes expired revocations and refresh tokens in the
+// background, they are useless once the token itself can no longer be verified
+func StartTokenPruner(interval time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			count, err := PruneExpiredTokens()
+			if err != nil {
+				fmt.Println("Token prune error:", err)
+				continue
+			}
+			if count > 0 {
+				fmt.Println("Pruned expired tokens:", count)
+			}
+		}
+	}()
+}
@@ -0,0 +1,142 @@
+package models
+
+type Role string
+
+const (
+	RoleOwner          Role = "owner"
+	RolePurchaser      Role = "purchaser"
+	RoleWarehouseClerk Role = "warehouse_clerk"
+	RoleCashier        Role = "cashier"
+	RoleViewer         Role = "viewer"
+)
+
+type Permission string
+
+const (
+	PermUsersManage             Permission = "users:manage"
+	PermAPIKeysManage           Permission = "api_keys:manage"
+	PermAuditLogsRead           Permission = "audit_logs:read"
+	PermCatalogRead             Permission = "catalog:read"
+	PermCatalogWrite            Permission = "catalog:write"
+	PermWarehousesRead          Permission = "warehouses:read"
+	PermWarehousesWrite         Permission = "warehouses:write"
+	PermStockTransfersRead      Permission = "stock_transfers:read"
+	PermStockTransfersWrite     Permission = "stock_transfers:write"
+	PermStockAdjustmentsRead    Permission = "stock_adjustments:read"
+	PermStockAdjustmentsWrite   Permission = "stock_adjustments:write"
+	PermStockAdjustmentsApprove Permission = "stock_adjustments:approve"
+	PermStocktakesRead          Permission = "stocktakes:read"
+	PermStocktakesWrite         Permission = "stocktakes:write"
+	PermStockReservationsRead   Permission = "stock_reservations:read"
+	PermStockReservationsWrite  Permission = "stock_reservations:write"
+	PermSuppliersRead           Permission = "suppliers:read"
+	PermSuppliersWrite          Permission = "suppliers:write"
+	PermSuppliersDelete         Permission = "suppliers:delete"
+	PermPurchaseOrdersRead      Permission = "purchase_orders:read"
+	PermPurchaseOrdersWrite     Permission = "purchase_orders:write"
+	PermPurchaseOrdersDelete    Permission = "purchase_orders:delete"
+	PermPurchaseOrdersReceive   Permission = "purchase_orders:receive"
+	PermPurchaseOrdersApprove   Permission = "purchase_orders:approve"
+)
+
+var AllPermissions = []Permission{
+	PermUsersManage,
+	PermAPIKeysManage,
+	PermAuditLogsRead,
+	PermCatalogRead,
+	PermCatalogWrite,
+	PermWarehousesRead,
+	PermWarehousesWrite,
+	PermStockTransfersRead,
+	PermStockTransfersWrite,
+	PermStockAdjustmentsRead,
+	PermStockAdjustmentsWrite,
+	PermStockAdjustmentsApprove,
+	PermStocktakesRead,
+	PermStocktakesWrite,
+	PermStockReservationsRead,
+	PermStockReservationsWrite,
+	PermSuppliersRead,
+	PermSuppliersWrite,
+	PermSuppliersDelete,
+	PermPurchaseOrdersRead,
+	PermPurchaseOrdersWrite,
+	PermPurchaseOrdersDelete,
+	PermPurchaseOrdersReceive,
+	PermPurchaseOrdersApprove,
+}
+
+func IsValidPermission(permission Permission) bool {
+
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions lists what each role may do, owner is allowed everything
+var RolePermissions = map[Role][]Permission{
+	RolePurchaser: {
+		PermCatalogRead,
+		PermCatalogWrite,
+		PermWarehousesRead,
+		PermSuppliersRead,
+		PermSuppliersWrite,
+		PermPurchaseOrdersRead,
+		PermPurchaseOrdersWrite,
+	},
+	RoleWarehouseClerk: {
+		PermCatalogRead,
+		PermWarehousesRead,
+		PermStockTransfersRead,
+		PermStockTransfersWrite,
+		PermStockAdjustmentsRead,
+		PermStockAdjustmentsWrite,
+		PermStocktakesRead,
+		PermStocktakesWrite,
+		PermStockReservationsRead,
+		PermStockReservationsWrite,
+		PermSuppliersRead,
+		PermPurchaseOrdersRead,
+		PermPurchaseOrdersReceive,
+	},
+	RoleCashier: {
+		PermCatalogRead,
+		PermStockReservationsRead,
+	},
+	RoleViewer: {
+		PermCatalogRead,
+		PermWarehousesRead,
+		PermStockTransfersRead,
+		PermStockAdjustmentsRead,
+		PermStocktakesRead,
+		PermStockReservationsRead,
+		PermSuppliersRead,
+		PermPurchaseOrdersRead,
+	},
+}
+
+func IsValidRole(role Role) bool {
+
+	switch role {
+	case RoleOwner, RolePurchaser, RoleWarehouseClerk, RoleCashier, RoleViewer:
+		return true
+	}
+	return false
+}
+
+func (role Role) HasPermission(permission Permission) bool {
+
+	if role == RoleOwner {
+		return true
+	}
+
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
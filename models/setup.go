@@ -38,6 +38,10 @@ func ConnectDatabase() {
 		fmt.Println("We are connected to the database ", Dbdriver)
 	}
 
+	if err := migratePurchaseOrderStatus(DB); err != nil {
+		log.Fatal("purchase order status migration error:", err)
+	}
+
 	DB.AutoMigrate(
 		&User{}, 
 		&ProductCategory{}, 
@@ -50,6 +54,32 @@ func ConnectDatabase() {
 		&ProductTags{},
 		&PurchaseOrder{},
 		&PurchaseOrderItem{},
+		&RevokedToken{},
+		&RefreshToken{},
+		&LoginThrottle{},
+		&PasswordResetToken{},
+		&APIKey{},
+		&RecoveryCode{},
+		&AuditLog{},
+		&Warehouse{},
+		&StockMovement{},
+		&StockTransfer{},
+		&StockTransferItem{},
+		&StockAdjustment{},
+		&StockAdjustmentItem{},
+		&Stocktake{},
+		&StocktakeItem{},
+		&StockLot{},
+		&ReorderPoint{},
+		&Notification{},
+		&ActiveAlert{},
+		&StockReservation{},
+		&PurchaseOrderApprovalRule{},
+		&PurchaseOrderApprovalRuleApprover{},
+		&PurchaseOrderApproval{},
+		&PurchaseOrderEmail{},
+		&GoodsReceivedNote{},
+		&GoodsReceivedNoteItem{},
 	)
 
 	// if err := DB.AutoMigrate(
@@ -0,0 +1,501 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AdjustmentStatus string
+
+const (
+	AdjustmentDraft           AdjustmentStatus = "draft"
+	AdjustmentPendingApproval AdjustmentStatus = "pending_approval"
+	AdjustmentPosted          AdjustmentStatus = "posted"
+	AdjustmentRejected        AdjustmentStatus = "rejected"
+)
+
+type AdjustmentReasonCode string
+
+const (
+	AdjustmentBreakage        AdjustmentReasonCode = "breakage"
+	AdjustmentSpoilage        AdjustmentReasonCode = "spoilage"
+	AdjustmentExpired         AdjustmentReasonCode = "expired"
+	AdjustmentTheft           AdjustmentReasonCode = "theft"
+	AdjustmentCountCorrection AdjustmentReasonCode = "count_correction"
+	AdjustmentOther           AdjustmentReasonCode = "other"
+)
+
+type StockAdjustment struct {
+	ID                   uint                  `gorm:"primary_key" json:"id"`
+	AdjustmentNo         string                `gorm:"index;size:255;unique" json:"adjustment_no"`
+	Warehouse            *Warehouse            `gorm:"foreignKey:WarehouseId" json:"warehouse"`
+	WarehouseId          uint                  `gorm:"index;not null" json:"warehouse_id" validate:"required"`
+	Status               AdjustmentStatus      `gorm:"type:enum('draft', 'pending_approval', 'posted', 'rejected');default:'draft'" json:"status"`
+	Note                 string                `gorm:"type:text" json:"note"`
+	TotalValue           float64               `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_value"`
+	StockAdjustmentItems []StockAdjustmentItem `json:"stock_adjustment_items" validate:"required,min=1,dive,required"`
+	SourceType           string                `gorm:"size:100" json:"source_type"`
+	SourceId             uint                  `gorm:"not null;default:0" json:"source_id"`
+	CreatedBy            uint                  `gorm:"not null;default:0" json:"created_by"`
+	ApprovedBy           uint                  `gorm:"not null;default:0" json:"approved_by"`
+	ApprovedAt           *time.Time            `json:"approved_at"`
+	RejectedReason       string                `gorm:"type:text" json:"rejected_reason"`
+	PostedAt             *time.Time            `json:"posted_at"`
+	CreatedAt            time.Time             `json:"created_at"`
+	UpdatedAt            time.Time             `json:"updated_at"`
+	DeletedAt            gorm.DeletedAt        `gorm:"index"`
+}
+
+// StockAdjustmentItem keeps the unit cost taken when the line was written,
+// later cost changes never revalue a past adjustment
+type StockAdjustmentItem struct {
+	ID                 uint                 `gorm:"primary_key" json:"id"`
+	StockAdjustmentId  uint                 `gorm:"index;not null" json:"stock_adjustment_id"`
+	ProductVariation   *ProductVariation    `gorm:"foreignKey:ProductVariationId" json:"product_variation"`
+	ProductVariationId uint                 `gorm:"index;not null" json:"product_variation_id" validate:"required"`
+	Qty                float64              `gorm:"type:decimal(12,2);not null" json:"qty" validate:"required"`
+	ReasonCode         AdjustmentReasonCode `gorm:"type:enum('breakage', 'spoilage', 'expired', 'theft', 'count_correction', 'other');not null" json:"reason_code" validate:"required"`
+	UnitCost           float64              `gorm:"type:decimal(12,2);not null;default:0.0" json:"unit_cost"`
+	TotalValue         float64              `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_value"`
+	Note               string               `gorm:"type:text" json:"note"`
+	CreatedAt          time.Time            `json:"created_at"`
+	UpdatedAt          time.Time            `json:"updated_at"`
+}
+
+type RejectStockAdjustment struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// BeforeCreate numbers the adjustment, only on create so later saves keep their number
+func (a *StockAdjustment) BeforeCreate(tx *gorm.DB) error {
+
+	no, err := nextDocumentNo(tx, &StockAdjustment{}, "A")
+	if err != nil {
+		return err
+	}
+	a.AdjustmentNo = no
+
+	return nil
+}
+
+// stockAdjustmentApprovalThreshold reads STOCK_ADJUSTMENT_APPROVAL_THRESHOLD,
+// when it is unset no adjustment needs approval
+func stockAdjustmentApprovalThreshold() (float64, bool) {
+
+	threshold, err := strconv.ParseFloat(os.Getenv("STOCK_ADJUSTMENT_APPROVAL_THRESHOLD"), 64)
+	if err != nil {
+		return 0, false
+	}
+	return threshold, true
+}
+
+func (a *StockAdjustment) needsApproval() bool {
+
+	threshold, enabled := stockAdjustmentApprovalThreshold()
+
+	return enabled && a.TotalValue > threshold
+}
+
+func isValidAdjustmentReasonCode(code AdjustmentReasonCode) bool {
+
+	switch code {
+	case AdjustmentBreakage, AdjustmentSpoilage, AdjustmentExpired, AdjustmentTheft, AdjustmentCountCorrection, AdjustmentOther:
+		return true
+	}
+	return false
+}
+
+// ledgerReason maps the reason code to the stock movement reason, goods
+// that were lost or went bad are wastage
+func (code AdjustmentReasonCode) ledgerReason() StockMovementReason {
+
+	switch code {
+	case AdjustmentBreakage, AdjustmentSpoilage, AdjustmentExpired, AdjustmentTheft:
+		return ReasonWastage
+	}
+	return ReasonAdjustment
+}
+
+func (code AdjustmentReasonCode) isLoss() bool {
+	return code.ledgerReason() == ReasonWastage
+}
+
+func (a *StockAdjustment) stockSource() StockSource {
+	return StockSource{Type: "stock_adjustments", Id: a.ID, No: a.AdjustmentNo}
+}
+
+// buildStockAdjustmentItems validates the lines and values them at the
+// current unit cost
+func buildStockAdjustmentItems(db *gorm.DB, warehouseId uint, items []StockAdjustmentItem) ([]StockAdjustmentItem, float64, error) {
+
+	if _, err := getActiveWarehouse(db, warehouseId); err != nil {
+		return nil, 0, err
+	}
+
+	var results []StockAdjustmentItem
+	var totalValue float64
+
+	for _, item := range items {
+		if !helper.IsRecordValidByID(item.ProductVariationId, &ProductVariation{}, db) {
+			return nil, 0, errors.New("invalid product variation id")
+		}
+		if !isValidAdjustmentReasonCode(item.ReasonCode) {
+			return nil, 0, errors.New("invalid reason code")
+		}
+		if item.Qty == 0 {
+			return nil, 0, errors.New("adjustment qty cannot be zero")
+		}
+		if item.ReasonCode.isLoss() && item.Qty > 0 {
+			return nil, 0, fmt.Errorf("%s can only reduce stock", item.ReasonCode)
+		}
+
+		unitCost, err := getVariationUnitCost(db, item.ProductVariationId)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		line := StockAdjustmentItem{
+			ProductVariationId: item.ProductVariationId,
+			Qty:                item.Qty,
+			ReasonCode:         item.ReasonCode,
+			UnitCost:           unitCost,
+			TotalValue:         item.Qty * unitCost,
+			Note:               item.Note,
+		}
+
+		results = append(results, line)
+		totalValue += math.Abs(line.TotalValue)
+	}
+
+	return results, totalValue, nil
+}
+
+func GetAllStockAdjustments(c *gin.Context) ([]StockAdjustment, error) {
+
+	var results []StockAdjustment
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	search := c.Query("search")
+	status := c.Query("status")
+	warehouseId := c.Query("warehouse_id")
+
+	db := DB.Model(&StockAdjustment{})
+
+	if search != "" {
+		db = db.Where("adjustment_no LIKE ? OR note LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if warehouseId != "" {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+
+	if err := utils.Paginate(db.Preload("Warehouse"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no stock adjustments")
+	}
+
+	return results, nil
+}
+
+func GetStockAdjustment(id uint64) (StockAdjustment, error) {
+
+	var result StockAdjustment
+
+	err := DB.Preload("Warehouse").
+			Preload("StockAdjustmentItems.ProductVariation").
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *StockAdjustment) CreateStockAdjustment(actor Actor) (*StockAdjustment, error) {
+
+	items, totalValue, err := buildStockAdjustmentItems(DB, input.WarehouseId, input.StockAdjustmentItems)
+	if err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	input.StockAdjustmentItems = items
+	input.TotalValue = totalValue
+	input.Status = AdjustmentDraft
+	input.SourceType = ""
+	input.SourceId = 0
+	input.CreatedBy = actor.UserId
+	input.ApprovedBy = 0
+	input.ApprovedAt = nil
+	input.RejectedReason = ""
+	input.PostedAt = nil
+
+	if err := DB.Create(&input).Error; err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "stock_adjustments", input.ID, nil, input)
+
+	return input, nil
+}
+
+// UpdateStockAdjustment replaces the lines of a draft or rejected
+// adjustment, a rejected one goes back to draft
+func (input *StockAdjustment) UpdateStockAdjustment(id uint64, actor Actor) (*StockAdjustment, error) {
+
+	tx := DB.Begin()
+
+	var existingAdjustment StockAdjustment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockAdjustmentItems").First(&existingAdjustment, id).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, helper.ErrorRecordNotFound
+	}
+
+	if existingAdjustment.Status != AdjustmentDraft && existingAdjustment.Status != AdjustmentRejected {
+		tx.Rollback()
+		return &StockAdjustment{}, errors.New("only draft adjustments can be changed")
+	}
+
+	items, totalValue, err := buildStockAdjustmentItems(tx, input.WarehouseId, input.StockAdjustmentItems)
+	if err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, err
+	}
+
+	before := existingAdjustment
+
+	if err := tx.Where("stock_adjustment_id = ?", id).Delete(&StockAdjustmentItem{}).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, err
+	}
+
+	existingAdjustment.WarehouseId = input.WarehouseId
+	existingAdjustment.Note = input.Note
+	existingAdjustment.TotalValue = totalValue
+	existingAdjustment.Status = AdjustmentDraft
+	existingAdjustment.RejectedReason = ""
+	existingAdjustment.StockAdjustmentItems = items
+
+	if err := tx.Omit("Warehouse").Save(&existingAdjustment).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, err
+	}
+
+	recordStockAdjustmentAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	return &existingAdjustment, nil
+}
+
+// recordStockAdjustmentAudit compares the adjustment and its lines against the saved state
+func recordStockAdjustmentAudit(tx *gorm.DB, actor Actor, before StockAdjustment) {
+
+	var after StockAdjustment
+	if err := tx.Preload("StockAdjustmentItems").First(&after, before.ID).Error; err == nil {
+		recordAudit(tx, actor, AuditUpdate, "stock_adjustments", after.ID, before, after)
+	}
+}
+
+// postStockAdjustment writes the lines to the ledger at their saved cost
+func postStockAdjustment(tx *gorm.DB, actor Actor, adjustment *StockAdjustment) error {
+
+	if _, err := getActiveWarehouse(tx, adjustment.WarehouseId); err != nil {
+		return err
+	}
+
+	for _, item := range adjustment.StockAdjustmentItems {
+		if item.Qty < 0 {
+			if err := checkStockOnHand(tx, item.ProductVariationId, adjustment.WarehouseId, -item.Qty); err != nil {
+				return err
+			}
+		}
+
+		err := recordStockMovement(tx, actor, adjustment.stockSource(), StockMovement{
+			ProductVariationId: item.ProductVariationId,
+			WarehouseId:        adjustment.WarehouseId,
+			Qty:                item.Qty,
+			Reason:             item.ReasonCode.ledgerReason(),
+			UnitCost:           item.UnitCost,
+			Note:               string(item.ReasonCode),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	adjustment.Status = AdjustmentPosted
+	adjustment.PostedAt = &now
+
+	return tx.Omit("StockAdjustmentItems", "Warehouse").Save(adjustment).Error
+}
+
+// SubmitStockAdjustment posts the adjustment straight away, or parks it for
+// approval when its value is above the threshold
+func SubmitStockAdjustment(id uint64, actor Actor) (*StockAdjustment, error) {
+
+	tx := DB.Begin()
+
+	var existingAdjustment StockAdjustment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockAdjustmentItems").First(&existingAdjustment, id).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, helper.ErrorRecordNotFound
+	}
+
+	if existingAdjustment.Status != AdjustmentDraft {
+		tx.Rollback()
+		return &StockAdjustment{}, errors.New("only draft adjustments can be submitted")
+	}
+
+	before := existingAdjustment
+
+	if existingAdjustment.needsApproval() {
+		existingAdjustment.Status = AdjustmentPendingApproval
+		if err := tx.Omit("StockAdjustmentItems").Save(&existingAdjustment).Error; err != nil {
+			tx.Rollback()
+			return &StockAdjustment{}, err
+		}
+	} else {
+		if err := postStockAdjustment(tx, actor, &existingAdjustment); err != nil {
+			tx.Rollback()
+			return &StockAdjustment{}, err
+		}
+	}
+
+	recordStockAdjustmentAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	return &existingAdjustment, nil
+}
+
+// ApproveStockAdjustment posts a pending adjustment, nobody approves their own
+func ApproveStockAdjustment(id uint64, actor Actor) (*StockAdjustment, error) {
+
+	tx := DB.Begin()
+
+	var existingAdjustment StockAdjustment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockAdjustmentItems").First(&existingAdjustment, id).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, helper.ErrorRecordNotFound
+	}
+
+	if existingAdjustment.Status != AdjustmentPendingApproval {
+		tx.Rollback()
+		return &StockAdjustment{}, errors.New("adjustment is not waiting for approval")
+	}
+
+	if actor.UserId == 0 || actor.UserId == existingAdjustment.CreatedBy {
+		tx.Rollback()
+		return &StockAdjustment{}, errors.New("adjustment must be approved by another user")
+	}
+
+	before := existingAdjustment
+
+	now := time.Now()
+	existingAdjustment.ApprovedBy = actor.UserId
+	existingAdjustment.ApprovedAt = &now
+
+	if err := postStockAdjustment(tx, actor, &existingAdjustment); err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, err
+	}
+
+	recordStockAdjustmentAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	return &existingAdjustment, nil
+}
+
+func (input *RejectStockAdjustment) RejectStockAdjustment(id uint64, actor Actor) (*StockAdjustment, error) {
+
+	tx := DB.Begin()
+
+	var existingAdjustment StockAdjustment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockAdjustmentItems").First(&existingAdjustment, id).Error; err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, helper.ErrorRecordNotFound
+	}
+
+	if existingAdjustment.Status != AdjustmentPendingApproval {
+		tx.Rollback()
+		return &StockAdjustment{}, errors.New("adjustment is not waiting for approval")
+	}
+
+	err := tx.Model(&StockAdjustment{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          AdjustmentRejected,
+		"rejected_reason": input.Reason,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return &StockAdjustment{}, err
+	}
+
+	recordStockAdjustmentAudit(tx, actor, existingAdjustment)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockAdjustment{}, err
+	}
+
+	existingAdjustment.Status = AdjustmentRejected
+	existingAdjustment.RejectedReason = input.Reason
+
+	return &existingAdjustment, nil
+}
+
+func (input *StockAdjustment) DeleteStockAdjustment(id uint64, actor Actor) (*StockAdjustment, error) {
+
+	tx := DB.Begin()
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockAdjustmentItems").First(input, id).Error; err != nil {
+		tx.Rollback()
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if input.Status == AdjustmentPosted || input.Status == AdjustmentPendingApproval {
+		tx.Rollback()
+		return nil, errors.New("submitted adjustments cannot be deleted")
+	}
+
+	before := *input
+
+	if err := tx.Where("stock_adjustment_id = ?", id).Delete(&StockAdjustmentItem{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Delete(&input).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	recordAudit(tx, actor, AuditDelete, "stock_adjustments", before.ID, before, nil)
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
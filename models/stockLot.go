@@ -0,0 +1,291 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"gorm.io/gorm"
+)
+
+// StockLot is a batch of one variation as it was received, stock of a lot
+// is the sum of the ledger rows carrying its id. Lot numbers are per variation
+type StockLot struct {
+	ID                 uint              `gorm:"primary_key" json:"id"`
+	ProductVariation   *ProductVariation `gorm:"foreignKey:ProductVariationId" json:"product_variation,omitempty"`
+	ProductVariationId uint              `gorm:"uniqueIndex:idx_stock_lot_variation_no;not null" json:"product_variation_id"`
+	LotNo              string            `gorm:"uniqueIndex:idx_stock_lot_variation_no;size:100;not null" json:"lot_no"`
+	ManufactureDate    *time.Time        `gorm:"type:date" json:"manufacture_date"`
+	ExpiryDate         *time.Time        `gorm:"type:date;index" json:"expiry_date"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+// ReceiveLot splits a received qty by lot, dates are given as 2006-01-02
+type ReceiveLot struct {
+	LotNo           string  `json:"lot_no" validate:"required,max=100"`
+	ManufactureDate string  `json:"manufacture_date"`
+	ExpiryDate      string  `json:"expiry_date"`
+	Qty             float64 `json:"qty" validate:"required,gt=0"`
+}
+
+type LotStock struct {
+	LotId           uint       `json:"lot_id"`
+	LotNo           string     `json:"lot_no"`
+	ManufactureDate *time.Time `json:"manufacture_date"`
+	ExpiryDate      *time.Time `json:"expiry_date"`
+	WarehouseId     uint       `json:"warehouse_id"`
+	WarehouseName   string     `json:"warehouse_name"`
+	OnHandQty       float64    `json:"on_hand_qty"`
+}
+
+type ExpiringLot struct {
+	LotId              uint       `json:"lot_id"`
+	LotNo              string     `json:"lot_no"`
+	ExpiryDate         *time.Time `json:"expiry_date"`
+	DaysToExpiry       int        `json:"days_to_expiry"`
+	ProductId          uint       `json:"product_id"`
+	Title              string     `json:"title"`
+	ProductVariationId uint       `json:"product_variation_id"`
+	VariantName        string     `json:"variant_name"`
+	SKU                string     `json:"sku"`
+	WarehouseId        uint       `json:"warehouse_id"`
+	WarehouseName      string     `json:"warehouse_name"`
+	OnHandQty          float64    `json:"on_hand_qty"`
+	StockValue         float64    `json:"stock_value"`
+}
+
+// lotQty is a part of a movement that belongs to one lot, lot 0 is stock
+// that was received without a lot number
+type lotQty struct {
+	LotId uint
+	Qty   float64
+}
+
+func parseLotDate(value string, field string) (*time.Time, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", field)
+	}
+	return &date, nil
+}
+
+// lotDate drops the time of day so dates compare by calendar day
+func lotDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func sameLotDate(a *time.Time, b *time.Time) bool {
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// findOrCreateStockLot returns the variation's lot with this number, a lot
+// received again must come with the same dates
+func findOrCreateStockLot(tx *gorm.DB, variationId uint, input ReceiveLot) (StockLot, error) {
+
+	var lot StockLot
+
+	lotNo := strings.TrimSpace(input.LotNo)
+	if lotNo == "" {
+		return lot, errors.New("lot no is required")
+	}
+
+	manufactureDate, err := parseLotDate(input.ManufactureDate, "manufacture_date")
+	if err != nil {
+		return lot, err
+	}
+	expiryDate, err := parseLotDate(input.ExpiryDate, "expiry_date")
+	if err != nil {
+		return lot, err
+	}
+	if manufactureDate != nil && expiryDate != nil && expiryDate.Before(*manufactureDate) {
+		return lot, errors.New("expiry date cannot be before manufacture date")
+	}
+
+	if err := tx.Where("product_variation_id = ? AND lot_no = ?", variationId, lotNo).Limit(1).Find(&lot).Error; err != nil {
+		return lot, err
+	}
+
+	if lot.ID != 0 {
+		if (expiryDate != nil && !sameLotDate(lot.ExpiryDate, expiryDate)) ||
+			(manufactureDate != nil && !sameLotDate(lot.ManufactureDate, manufactureDate)) {
+			return lot, fmt.Errorf("lot %s was received before with different dates", lotNo)
+		}
+		return lot, nil
+	}
+
+	lot = StockLot{
+		ProductVariationId: variationId,
+		LotNo:              lotNo,
+		ManufactureDate:    manufactureDate,
+		ExpiryDate:         expiryDate,
+	}
+	if err := tx.Create(&lot).Error; err != nil {
+		return lot, err
+	}
+
+	return lot, nil
+}
+
+// allocateLots spreads qty over the lots in the order given, whatever the
+// lots cannot cover is left on lot 0
+func allocateLots(available []lotQty, qty float64) []lotQty {
+
+	var results []lotQty
+
+	for _, lot := range available {
+		if qty <= 0 {
+			break
+		}
+		if lot.Qty <= 0 {
+			continue
+		}
+
+		take := lot.Qty
+		if take > qty {
+			take = qty
+		}
+		results = append(results, lotQty{LotId: lot.LotId, Qty: take})
+		qty -= take
+	}
+
+	if qty > 0 {
+		results = append(results, lotQty{LotId: 0, Qty: qty})
+	}
+	return results
+}
+
+// allocateLotsFEFO picks the lots an outgoing qty is taken from, the lot
+// expiring first goes first and lots without expiry go last
+func allocateLotsFEFO(tx *gorm.DB, variationId uint, warehouseId uint, qty float64) ([]lotQty, error) {
+
+	var available []lotQty
+
+	err := tx.Table("stock_movements").
+			Select("stock_movements.lot_id, SUM(stock_movements.qty) AS qty").
+			Joins("JOIN stock_lots ON stock_lots.id = stock_movements.lot_id").
+			Where("stock_movements.product_variation_id = ? AND stock_movements.warehouse_id = ?", variationId, warehouseId).
+			Group("stock_movements.lot_id, stock_lots.expiry_date").
+			Having("SUM(stock_movements.qty) > 0").
+			Order("stock_lots.expiry_date IS NULL, stock_lots.expiry_date, stock_movements.lot_id").
+			Scan(&available).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return allocateLots(available, qty), nil
+}
+
+func GetVariationLots(c *gin.Context, variationId uint64) ([]LotStock, error) {
+
+	results := []LotStock{}
+
+	if !helper.IsRecordValidByID(uint(variationId), &ProductVariation{}, DB) {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	warehouseId := c.Query("warehouse_id")
+	includeEmpty := c.Query("include_empty") == "true"
+
+	db := DB.Table("stock_movements").
+			Select("stock_lots.id AS lot_id, stock_lots.lot_no, stock_lots.manufacture_date, stock_lots.expiry_date, " +
+				"stock_movements.warehouse_id, warehouses.name AS warehouse_name, SUM(stock_movements.qty) AS on_hand_qty").
+			Joins("JOIN stock_lots ON stock_lots.id = stock_movements.lot_id").
+			Joins("LEFT JOIN warehouses ON warehouses.id = stock_movements.warehouse_id").
+			Where("stock_movements.product_variation_id = ?", variationId)
+
+	if warehouseId != "" {
+		db = db.Where("stock_movements.warehouse_id = ?", warehouseId)
+	}
+
+	db = db.Group("stock_lots.id, stock_lots.lot_no, stock_lots.manufacture_date, stock_lots.expiry_date, " +
+		"stock_movements.warehouse_id, warehouses.name")
+
+	if !includeEmpty {
+		db = db.Having("SUM(stock_movements.qty) <> 0")
+	}
+
+	if err := db.Order("stock_lots.expiry_date IS NULL, stock_lots.expiry_date, stock_lots.id, stock_movements.warehouse_id").Scan(&results).Error; err != nil {
+		return results, errors.New("error fetching lots")
+	}
+
+	return results, nil
+}
+
+// GetExpiringLots reports lots still in stock that expire within the next
+// days (30 unless given), lots that already expired are included
+func GetExpiringLots(c *gin.Context) ([]ExpiringLot, error) {
+
+	results := []ExpiringLot{}
+
+	days := 30
+	if daysParam := c.Query("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed < 0 {
+			return results, errors.New("invalid days")
+		}
+		days = parsed
+	}
+
+	results, err := getExpiringLots(days, c.Query("warehouse_id"))
+	if err != nil {
+		return results, errors.New("error fetching expiring lots")
+	}
+
+	return results, nil
+}
+
+func getExpiringLots(days int, warehouseId string) ([]ExpiringLot, error) {
+
+	results := []ExpiringLot{}
+
+	today := lotDate(time.Now())
+	until := today.AddDate(0, 0, days).Format("2006-01-02")
+
+	db := DB.Table("stock_movements").
+			Select("stock_lots.id AS lot_id, stock_lots.lot_no, stock_lots.expiry_date, " +
+				"products.id AS product_id, products.title, product_variations.id AS product_variation_id, " +
+				"product_variations.variant_name, product_variations.sku, " +
+				"stock_movements.warehouse_id, warehouses.name AS warehouse_name, " +
+				"SUM(stock_movements.qty) AS on_hand_qty, SUM(stock_movements.qty * stock_movements.unit_cost) AS stock_value").
+			Joins("JOIN stock_lots ON stock_lots.id = stock_movements.lot_id").
+			Joins("JOIN product_variations ON product_variations.id = stock_movements.product_variation_id").
+			Joins("JOIN products ON products.id = product_variations.product_id").
+			Joins("LEFT JOIN warehouses ON warehouses.id = stock_movements.warehouse_id").
+			Where("stock_lots.expiry_date IS NOT NULL AND stock_lots.expiry_date <= ?", until)
+
+	if warehouseId != "" {
+		db = db.Where("stock_movements.warehouse_id = ?", warehouseId)
+	}
+
+	err := db.Group("stock_lots.id, stock_lots.lot_no, stock_lots.expiry_date, products.id, products.title, " +
+			"product_variations.id, product_variations.variant_name, product_variations.sku, " +
+			"stock_movements.warehouse_id, warehouses.name").
+			Having("SUM(stock_movements.qty) > 0").
+			Order("stock_lots.expiry_date, products.title, product_variations.variant_name").
+			Scan(&results).Error
+	if err != nil {
+		return results, err
+	}
+
+	for i := range results {
+		if results[i].ExpiryDate != nil {
+			results[i].DaysToExpiry = int(lotDate(*results[i].ExpiryDate).Sub(today).Hours() / 24)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,451 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StockMovementReason string
+
+const (
+	ReasonPurchaseReceipt StockMovementReason = "purchase_receipt"
+	ReasonSale            StockMovementReason = "sale"
+	ReasonAdjustment      StockMovementReason = "adjustment"
+	ReasonTransfer        StockMovementReason = "transfer"
+	ReasonWastage         StockMovementReason = "wastage"
+)
+
+var ErrorStockMovementImmutable = errors.New("stock movements cannot be changed")
+
+var ErrorInsufficientStock = errors.New("not enough stock on hand")
+
+// StockMovement is one line of the stock ledger, rows are only ever added
+// and on hand quantities are the sum of Qty
+type StockMovement struct {
+	ID                 uint                `gorm:"primary_key" json:"id"`
+	ProductVariation   *ProductVariation   `gorm:"foreignKey:ProductVariationId" json:"product_variation,omitempty"`
+	ProductVariationId uint                `gorm:"index:idx_stock_movement_location;not null" json:"product_variation_id"`
+	WarehouseId        uint                `gorm:"index:idx_stock_movement_location;not null;default:0" json:"warehouse_id"`
+	LotId              uint                `gorm:"index;not null;default:0" json:"lot_id"`
+	Qty                float64             `gorm:"type:decimal(12,2);not null" json:"qty"`
+	UnitCost           float64             `gorm:"type:decimal(12,2);not null;default:0.0" json:"unit_cost"`
+	Reason             StockMovementReason `gorm:"type:enum('purchase_receipt', 'sale', 'adjustment', 'transfer', 'wastage');not null" json:"reason"`
+	SourceType         string              `gorm:"size:100;index:idx_stock_movement_source" json:"source_type"`
+	SourceId           uint                `gorm:"index:idx_stock_movement_source" json:"source_id"`
+	SourceNo           string              `gorm:"size:255" json:"source_no"`
+	UserId             uint                `gorm:"index;not null;default:0" json:"user_id"`
+	ApiKeyId           uint                `gorm:"not null;default:0" json:"api_key_id"`
+	Note               string              `gorm:"type:text" json:"note"`
+	CreatedAt          time.Time           `gorm:"index" json:"created_at"`
+}
+
+// StockSource is the document a movement came from, e.g. a purchase order
+type StockSource struct {
+	Type string
+	Id   uint
+	No   string
+}
+
+func (movement *StockMovement) BeforeUpdate(*gorm.DB) error {
+	return ErrorStockMovementImmutable
+}
+
+func (movement *StockMovement) BeforeDelete(*gorm.DB) error {
+	return ErrorStockMovementImmutable
+}
+
+type VariationStock struct {
+	ProductVariationId uint             `json:"product_variation_id"`
+	VariantName        string           `json:"variant_name"`
+	SKU                string           `json:"sku"`
+	Barcode            string           `json:"barcode"`
+	OnHandQty          float64          `json:"on_hand_qty"`
+	ReservedQty        float64          `json:"reserved_qty"`
+	AvailableQty       float64          `json:"available_qty"`
+	InTransitQty       float64          `json:"in_transit_qty"`
+	Warehouses         []WarehouseStock `json:"warehouses"`
+}
+
+type ProductStock struct {
+	ProductId      uint             `json:"product_id"`
+	Title          string           `json:"title"`
+	IsQtyTracked   bool             `json:"is_qty_tracked"`
+	TotalOnHandQty float64          `json:"total_on_hand_qty"`
+	Variations     []VariationStock `json:"variations"`
+}
+
+// isVariationQtyTracked tells whether stock is kept for the variation's product
+func isVariationQtyTracked(db *gorm.DB, variationId uint) (bool, error) {
+
+	var count int64
+
+	err := db.Table("product_variations").
+			Joins("JOIN products ON products.id = product_variations.product_id").
+			Where("product_variations.id = ? AND products.is_qty_tracked = ? AND products.deleted_at IS NULL", variationId, true).
+			Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// recordStockMovement is the only way stock changes, run it with the
+// caller's transaction so the ledger moves together with the document.
+// A movement without a unit cost is valued at the variation's current cost,
+// an outgoing movement without a lot is split over the lots FEFO
+func recordStockMovement(tx *gorm.DB, actor Actor, source StockSource, movement StockMovement) error {
+
+	if movement.Qty == 0 {
+		return nil
+	}
+
+	tracked, err := isVariationQtyTracked(tx, movement.ProductVariationId)
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		return nil
+	}
+
+	if movement.UnitCost == 0 {
+		if movement.UnitCost, err = getVariationUnitCost(tx, movement.ProductVariationId); err != nil {
+			return err
+		}
+	}
+
+	movement.ID = 0
+	movement.SourceType = source.Type
+	movement.SourceId = source.Id
+	movement.SourceNo = source.No
+	movement.UserId = actor.UserId
+	movement.ApiKeyId = actor.APIKeyId
+
+	if movement.Qty > 0 || movement.LotId != 0 {
+		return tx.Create(&movement).Error
+	}
+
+	lots, err := allocateLotsFEFO(lockingRead(tx), movement.ProductVariationId, movement.WarehouseId, -movement.Qty)
+	if err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		lotMovement := movement
+		lotMovement.LotId = lot.LotId
+		lotMovement.Qty = -lot.Qty
+
+		if err := tx.Create(&lotMovement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getVariationUnitCost is the cost of the last purchase receipt, or the
+// product cost when the variation was never bought
+func getVariationUnitCost(tx *gorm.DB, variationId uint) (float64, error) {
+
+	var lastReceipt StockMovement
+
+	err := tx.Where("product_variation_id = ? AND reason = ? AND unit_cost > 0", variationId, ReasonPurchaseReceipt).
+			Order("id DESC").
+			Limit(1).
+			Find(&lastReceipt).Error
+	if err != nil {
+		return 0, err
+	}
+	if lastReceipt.ID != 0 {
+		return lastReceipt.UnitCost, nil
+	}
+
+	var cost float64
+
+	err = tx.Table("products").
+			Select("products.cost").
+			Joins("JOIN product_variations ON product_variations.product_id = products.id").
+			Where("product_variations.id = ?", variationId).
+			Scan(&cost).Error
+
+	return cost, err
+}
+
+// lockVariationStock holds the variation row until tx ends, take it before
+// checking a balance so two documents cannot both take the same stock
+func lockVariationStock(tx *gorm.DB, variationId uint) error {
+
+	var variation ProductVariation
+
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&variation, variationId).Error
+}
+
+// lockingRead makes a read see the latest committed rows, under MySQL's
+// repeatable read a plain read in tx keeps seeing the snapshot taken at its
+// first read, so a balance checked after waiting on lockVariationStock
+// would miss what the transaction holding the lock just committed
+func lockingRead(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+func getWarehouseOnHandQty(tx *gorm.DB, variationId uint, warehouseId uint) (float64, error) {
+
+	var qty float64
+
+	err := tx.Model(&StockMovement{}).
+			Select("COALESCE(SUM(qty), 0)").
+			Where("product_variation_id = ? AND warehouse_id = ?", variationId, warehouseId).
+			Scan(&qty).Error
+
+	return qty, err
+}
+
+// checkStockAvailable locks the variation and makes sure qty can be taken
+// out of the warehouse without touching reserved stock, untracked products
+// always pass
+func checkStockAvailable(tx *gorm.DB, variationId uint, warehouseId uint, qty float64) error {
+	return checkStock(tx, variationId, warehouseId, qty, true)
+}
+
+// checkStockOnHand is checkStockAvailable for goods that leave whether they
+// are reserved or not, like breakage or a reservation being fulfilled
+func checkStockOnHand(tx *gorm.DB, variationId uint, warehouseId uint, qty float64) error {
+	return checkStock(tx, variationId, warehouseId, qty, false)
+}
+
+func checkStock(tx *gorm.DB, variationId uint, warehouseId uint, qty float64, withoutReserved bool) error {
+
+	tracked, err := isVariationQtyTracked(tx, variationId)
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		return nil
+	}
+
+	if err := lockVariationStock(tx, variationId); err != nil {
+		return err
+	}
+
+	available, err := getWarehouseOnHandQty(lockingRead(tx), variationId, warehouseId)
+	if err != nil {
+		return err
+	}
+
+	if withoutReserved {
+		reserved, err := getWarehouseReservedQty(lockingRead(tx), variationId, warehouseId)
+		if err != nil {
+			return err
+		}
+		available -= reserved
+	}
+
+	if available < qty {
+		return ErrorInsufficientStock
+	}
+	return nil
+}
+
+func getOnHandQtys(variationIds []uint) (map[uint]float64, error) {
+
+	results := make(map[uint]float64)
+
+	if len(variationIds) == 0 {
+		return results, nil
+	}
+
+	var rows []struct {
+		ProductVariationId uint
+		OnHandQty          float64
+	}
+
+	err := DB.Model(&StockMovement{}).
+			Select("product_variation_id, SUM(qty) AS on_hand_qty").
+			Where("product_variation_id IN ?", variationIds).
+			Group("product_variation_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		results[row.ProductVariationId] = row.OnHandQty
+	}
+	return results, nil
+}
+
+// getWarehouseQtys breaks the on hand quantities down by location
+func getWarehouseQtys(variationIds []uint) (map[uint][]WarehouseStock, error) {
+
+	results := make(map[uint][]WarehouseStock)
+
+	if len(variationIds) == 0 {
+		return results, nil
+	}
+
+	var rows []struct {
+		ProductVariationId uint
+		WarehouseId        uint
+		WarehouseName      string
+		OnHandQty          float64
+	}
+
+	err := DB.Table("stock_movements").
+			Select("stock_movements.product_variation_id, stock_movements.warehouse_id, warehouses.name AS warehouse_name, SUM(stock_movements.qty) AS on_hand_qty").
+			Joins("LEFT JOIN warehouses ON warehouses.id = stock_movements.warehouse_id").
+			Where("stock_movements.product_variation_id IN ?", variationIds).
+			Group("stock_movements.product_variation_id, stock_movements.warehouse_id, warehouses.name").
+			Order("stock_movements.warehouse_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		results[row.ProductVariationId] = append(results[row.ProductVariationId], WarehouseStock{
+			WarehouseId:   row.WarehouseId,
+			WarehouseName: row.WarehouseName,
+			OnHandQty:     row.OnHandQty,
+		})
+	}
+	return results, nil
+}
+
+// fillVariationStock sets OnHandQty on the loaded variations of a product
+func fillVariationStock(variations []ProductVariation) error {
+
+	var ids []uint
+	for _, variation := range variations {
+		ids = append(ids, variation.ID)
+	}
+
+	qtys, err := getOnHandQtys(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range variations {
+		variations[i].OnHandQty = qtys[variations[i].ID]
+	}
+	return nil
+}
+
+func GetProductStock(id uint64) (ProductStock, error) {
+
+	var product Product
+
+	if err := DB.Preload("ProductVariations").First(&product, id).Error; err != nil {
+		return ProductStock{}, helper.ErrorRecordNotFound
+	}
+
+	if err := fillVariationStock(product.ProductVariations); err != nil {
+		return ProductStock{}, errors.New("error fetching stock")
+	}
+
+	result := ProductStock{
+		ProductId:    product.ID,
+		Title:        product.Title,
+		IsQtyTracked: product.IsQtyTracked,
+		Variations:   []VariationStock{},
+	}
+
+	var ids []uint
+	for _, variation := range product.ProductVariations {
+		ids = append(ids, variation.ID)
+	}
+
+	warehouseQtys, err := getWarehouseQtys(ids)
+	if err != nil {
+		return ProductStock{}, errors.New("error fetching stock")
+	}
+
+	inTransitQtys, err := getInTransitQtys(ids)
+	if err != nil {
+		return ProductStock{}, errors.New("error fetching stock")
+	}
+
+	reservedQtys, err := getReservedQtys(ids)
+	if err != nil {
+		return ProductStock{}, errors.New("error fetching stock")
+	}
+
+	for _, variation := range product.ProductVariations {
+		warehouses := warehouseQtys[variation.ID]
+		if warehouses == nil {
+			warehouses = []WarehouseStock{}
+		}
+
+		var reservedQty float64
+		for i := range warehouses {
+			warehouses[i].ReservedQty = reservedQtys[variation.ID][warehouses[i].WarehouseId]
+			warehouses[i].AvailableQty = warehouses[i].OnHandQty - warehouses[i].ReservedQty
+			reservedQty += warehouses[i].ReservedQty
+		}
+
+		result.Variations = append(result.Variations, VariationStock{
+			ProductVariationId: variation.ID,
+			VariantName:        variation.VariantName,
+			SKU:                variation.SKU,
+			Barcode:            variation.Barcode,
+			OnHandQty:          variation.OnHandQty,
+			ReservedQty:        reservedQty,
+			AvailableQty:       variation.OnHandQty - reservedQty,
+			InTransitQty:       inTransitQtys[variation.ID],
+			Warehouses:         warehouses,
+		})
+		result.TotalOnHandQty += variation.OnHandQty
+	}
+
+	return result, nil
+}
+
+func GetStockMovements(c *gin.Context, variationId uint64) ([]StockMovement, error) {
+
+	var results []StockMovement
+
+	if !helper.IsRecordValidByID(uint(variationId), &ProductVariation{}, DB) {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	reason := c.Query("reason")
+	warehouseId := c.Query("warehouse_id")
+	fromDate := c.Query("from_date")
+	toDate := c.Query("to_date")
+
+	db := DB.Model(&StockMovement{}).Where("product_variation_id = ?", variationId)
+
+	if reason != "" {
+		db = db.Where("reason = ?", reason)
+	}
+	if warehouseId != "" {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+	if fromDate != "" {
+		from, err := time.Parse("2006-01-02", fromDate)
+		if err != nil {
+			return results, errors.New("invalid from_date")
+		}
+		db = db.Where("created_at >= ?", from)
+	}
+	if toDate != "" {
+		to, err := time.Parse("2006-01-02", toDate)
+		if err != nil {
+			return results, errors.New("invalid to_date")
+		}
+		db = db.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	if err := utils.Paginate(db, pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no stock movements")
+	}
+
+	return results, nil
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "active"
+	ReservationReleased  ReservationStatus = "released"
+	ReservationFulfilled ReservationStatus = "fulfilled"
+	ReservationExpired   ReservationStatus = "expired"
+)
+
+// StockReservation holds stock of a warehouse for a source document, e.g. a
+// confirmed wholesale order. Active reservations that have not expired
+// are taken off the available qty
+type StockReservation struct {
+	ID                 uint              `gorm:"primary_key" json:"id"`
+	ProductVariation   *ProductVariation `gorm:"foreignKey:ProductVariationId" json:"product_variation,omitempty"`
+	ProductVariationId uint              `gorm:"index:idx_stock_reservation_location;not null" json:"product_variation_id"`
+	WarehouseId        uint              `gorm:"index:idx_stock_reservation_location;not null" json:"warehouse_id"`
+	Qty                float64           `gorm:"type:decimal(12,2);not null" json:"qty"`
+	Status             ReservationStatus `gorm:"type:enum('active', 'released', 'fulfilled', 'expired');default:'active';index" json:"status"`
+	SourceType         string            `gorm:"size:100;index:idx_stock_reservation_source;not null" json:"source_type"`
+	SourceId           uint              `gorm:"index:idx_stock_reservation_source;not null" json:"source_id"`
+	SourceNo           string            `gorm:"size:255" json:"source_no"`
+	ExpiresAt          *time.Time        `gorm:"index" json:"expires_at"`
+	Note               string            `gorm:"type:text" json:"note"`
+	UserId             uint              `gorm:"not null;default:0" json:"user_id"`
+	ApiKeyId           uint              `gorm:"not null;default:0" json:"api_key_id"`
+	ClosedAt           *time.Time        `json:"closed_at"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+// CreateStockReservations reserves all lines for one source document or
+// none of them
+type CreateStockReservations struct {
+	WarehouseId uint                   `json:"warehouse_id" validate:"required"`
+	SourceType  string                 `json:"source_type" validate:"required,max=100"`
+	SourceId    uint                   `json:"source_id" validate:"required"`
+	SourceNo    string                 `json:"source_no" validate:"max=255"`
+	ExpiresAt   *time.Time             `json:"expires_at"`
+	Note        string                 `json:"note"`
+	Items       []StockReservationItem `json:"items" validate:"required,min=1,dive,required"`
+}
+
+type StockReservationItem struct {
+	ProductVariationId uint    `json:"product_variation_id" validate:"required"`
+	Qty                float64 `json:"qty" validate:"required,gt=0"`
+}
+
+// activeReservations limits a query to reservations still holding stock
+func activeReservations(db *gorm.DB) *gorm.DB {
+	return db.Where("stock_reservations.status = ? AND (stock_reservations.expires_at IS NULL OR stock_reservations.expires_at > ?)",
+		ReservationActive, time.Now())
+}
+
+func getWarehouseReservedQty(tx *gorm.DB, variationId uint, warehouseId uint) (float64, error) {
+
+	var qty float64
+
+	err := activeReservations(tx.Model(&StockReservation{})).
+			Select("COALESCE(SUM(qty), 0)").
+			Where("product_variation_id = ? AND warehouse_id = ?", variationId, warehouseId).
+			Scan(&qty).Error
+
+	return qty, err
+}
+
+// getReservedQtys returns the reserved qty per variation and warehouse
+func getReservedQtys(variationIds []uint) (map[uint]map[uint]float64, error) {
+
+	results := make(map[uint]map[uint]float64)
+
+	if len(variationIds) == 0 {
+		return results, nil
+	}
+
+	var rows []struct {
+		ProductVariationId uint
+		WarehouseId        uint
+		Qty                float64
+	}
+
+	err := activeReservations(DB.Model(&StockReservation{})).
+			Select("product_variation_id, warehouse_id, SUM(qty) AS qty").
+			Where("product_variation_id IN ?", variationIds).
+			Group("product_variation_id, warehouse_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		if results[row.ProductVariationId] == nil {
+			results[row.ProductVariationId] = make(map[uint]float64)
+		}
+		results[row.ProductVariationId][row.WarehouseId] = row.Qty
+	}
+	return results, nil
+}
+
+func GetAllStockReservations(c *gin.Context) ([]StockReservation, error) {
+
+	var results []StockReservation
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	status := c.Query("status")
+	sourceType := c.Query("source_type")
+	sourceId := c.Query("source_id")
+	variationId := c.Query("product_variation_id")
+	warehouseId := c.Query("warehouse_id")
+
+	db := DB.Model(&StockReservation{})
+
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if sourceType != "" {
+		db = db.Where("source_type = ?", sourceType)
+	}
+	if sourceId != "" {
+		db = db.Where("source_id = ?", sourceId)
+	}
+	if variationId != "" {
+		db = db.Where("product_variation_id = ?", variationId)
+	}
+	if warehouseId != "" {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+
+	if err := utils.Paginate(db.Preload("ProductVariation"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no stock reservations")
+	}
+
+	return results, nil
+}
+
+func GetStockReservation(id uint64) (StockReservation, error) {
+
+	var result StockReservation
+
+	if err := DB.Preload("ProductVariation").First(&result, id).Error; err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+// CreateStockReservations locks every variation before checking what is
+// available, in id order so two orders for the same goods cannot deadlock,
+// and the second order waits until the first has committed its reservation
+func (input *CreateStockReservations) CreateStockReservations(actor Actor) ([]StockReservation, error) {
+
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expires at must be in the future")
+	}
+
+	if _, err := getActiveWarehouse(DB, input.WarehouseId); err != nil {
+		return nil, err
+	}
+
+	qtys := make(map[uint]float64)
+	for _, item := range input.Items {
+		tracked, err := isVariationQtyTracked(DB, item.ProductVariationId)
+		if err != nil {
+			return nil, err
+		}
+		if !tracked {
+			return nil, fmt.Errorf("product variation %d is not stock tracked", item.ProductVariationId)
+		}
+		qtys[item.ProductVariationId] += item.Qty
+	}
+
+	var variationIds []uint
+	for variationId := range qtys {
+		variationIds = append(variationIds, variationId)
+	}
+	sort.Slice(variationIds, func(i, j int) bool { return variationIds[i] < variationIds[j] })
+
+	tx := DB.Begin()
+
+	var reservations []StockReservation
+
+	for _, variationId := range variationIds {
+		if err := checkStockAvailable(tx, variationId, input.WarehouseId, qtys[variationId]); err != nil {
+			tx.Rollback()
+			if err == ErrorInsufficientStock {
+				return nil, fmt.Errorf("not enough stock available for product variation %d", variationId)
+			}
+			return nil, err
+		}
+
+		reservation := StockReservation{
+			ProductVariationId: variationId,
+			WarehouseId:        input.WarehouseId,
+			Qty:                qtys[variationId],
+			Status:             ReservationActive,
+			SourceType:         input.SourceType,
+			SourceId:           input.SourceId,
+			SourceNo:           input.SourceNo,
+			ExpiresAt:          input.ExpiresAt,
+			Note:               input.Note,
+			UserId:             actor.UserId,
+			ApiKeyId:           actor.APIKeyId,
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		recordAudit(tx, actor, AuditCreate, "stock_reservations", reservation.ID, nil, reservation)
+
+		reservations = append(reservations, reservation)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+// closeStockReservation ends an active reservation, a fulfilled one also
+// takes the goods out of the warehouse as a sale
+func closeStockReservation(id uint64, actor Actor, status ReservationStatus) (*StockReservation, error) {
+
+	tx := DB.Begin()
+
+	// the row stays locked until commit, a second fulfil waits here and
+	// then finds the reservation closed
+	var existingReservation StockReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingReservation, id).Error; err != nil {
+		tx.Rollback()
+		return &StockReservation{}, helper.ErrorRecordNotFound
+	}
+
+	if existingReservation.Status != ReservationActive {
+		tx.Rollback()
+		return &StockReservation{}, errors.New("reservation is not active")
+	}
+
+	before := existingReservation
+
+	if status == ReservationFulfilled {
+		if existingReservation.ExpiresAt != nil && !existingReservation.ExpiresAt.After(time.Now()) {
+			tx.Rollback()
+			return &StockReservation{}, errors.New("reservation has expired")
+		}
+
+		if err := checkStockOnHand(tx, existingReservation.ProductVariationId, existingReservation.WarehouseId, existingReservation.Qty); err != nil {
+			tx.Rollback()
+			return &StockReservation{}, err
+		}
+
+		source := StockSource{Type: existingReservation.SourceType, Id: existingReservation.SourceId, No: existingReservation.SourceNo}
+		if err := recordStockMovement(tx, actor, source, StockMovement{
+			ProductVariationId: existingReservation.ProductVariationId,
+			WarehouseId:        existingReservation.WarehouseId,
+			Qty:                -existingReservation.Qty,
+			Reason:             ReasonSale,
+		}); err != nil {
+			tx.Rollback()
+			return &StockReservation{}, err
+		}
+	}
+
+	now := time.Now()
+	existingReservation.Status = status
+	existingReservation.ClosedAt = &now
+
+	if err := tx.Save(&existingReservation).Error; err != nil {
+		tx.Rollback()
+		return &StockReservation{}, err
+	}
+
+	recordAudit(tx, actor, AuditUpdate, "stock_reservations", existingReservation.ID, before, existingReservation)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockReservation{}, err
+	}
+
+	return &existingReservation, nil
+}
+
+func ReleaseStockReservation(id uint64, actor Actor) (*StockReservation, error) {
+	return closeStockReservation(id, actor, ReservationReleased)
+}
+
+func FulfilStockReservation(id uint64, actor Actor) (*StockReservation, error) {
+	return closeStockReservation(id, actor, ReservationFulfilled)
+}
+
+// ExpireStockReservations marks reservations past their expiry, they stop
+// counting as reserved at expiry already, this only tidies the status
+func ExpireStockReservations() (int64, error) {
+
+	now := time.Now()
+
+	result := DB.Model(&StockReservation{}).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", ReservationActive, now).
+		Updates(map[string]interface{}{"status": ReservationExpired, "closed_at": now})
+
+	return result.RowsAffected, result.Error
+}
+
+func StartReservationExpirer(interval time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			count, err := ExpireStockReservations()
+			if err != nil {
+				fmt.Println("Reservation expiry error:", err)
+				continue
+			}
+			if count > 0 {
+				fmt.Println("Expired stock reservations:", count)
+			}
+		}
+	}()
+}
@@ -0,0 +1,582 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TransferStatus string
+
+const (
+	TransferDraft      TransferStatus = "draft"
+	TransferDispatched TransferStatus = "dispatched"
+	TransferPartial    TransferStatus = "partial"
+	TransferReceived   TransferStatus = "received"
+	TransferCancelled  TransferStatus = "cancelled"
+)
+
+type StockTransfer struct {
+	ID                 uint                `gorm:"primary_key" json:"id"`
+	TransferNo         string              `gorm:"index;size:255;unique" json:"transfer_no"`
+	FromWarehouse      *Warehouse          `gorm:"foreignKey:FromWarehouseId" json:"from_warehouse"`
+	FromWarehouseId    uint                `gorm:"index;not null" json:"from_warehouse_id" validate:"required"`
+	ToWarehouse        *Warehouse          `gorm:"foreignKey:ToWarehouseId" json:"to_warehouse"`
+	ToWarehouseId      uint                `gorm:"index;not null" json:"to_warehouse_id" validate:"required"`
+	Status             TransferStatus      `gorm:"type:enum('draft', 'dispatched', 'partial', 'received', 'cancelled');default:'draft'" json:"status"`
+	Note               string              `gorm:"type:text" json:"note"`
+	TotalQty           float64             `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_qty"`
+	TotalReceivedQty   float64             `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_received_qty"`
+	TotalMissingQty    float64             `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_missing_qty"`
+	TotalInTransitQty  float64             `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_in_transit_qty"`
+	StockTransferItems []StockTransferItem `json:"stock_transfer_items" validate:"required,min=1,dive,required"`
+	CreatedBy          uint                `gorm:"not null;default:0" json:"created_by"`
+	DispatchedBy       uint                `gorm:"not null;default:0" json:"dispatched_by"`
+	DispatchedAt       *time.Time          `json:"dispatched_at"`
+	ReceivedAt         *time.Time          `json:"received_at"`
+	CreatedAt          time.Time           `json:"created_at"`
+	UpdatedAt          time.Time           `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt      `gorm:"index"`
+}
+
+type StockTransferItem struct {
+	ID                 uint              `gorm:"primary_key" json:"id"`
+	StockTransferId    uint              `gorm:"index;not null" json:"stock_transfer_id"`
+	ProductVariation   *ProductVariation `gorm:"foreignKey:ProductVariationId" json:"product_variation"`
+	ProductVariationId uint              `gorm:"index;not null" json:"product_variation_id" validate:"required"`
+	Qty                float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"qty" validate:"required,gt=0"`
+	ReceivedStatus     Status            `gorm:"type:enum('pending', 'partial', 'complete');default:'pending'" json:"received_status"`
+	TotalReceivedQty   float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_received_qty"`
+	TotalMissingQty    float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_missing_qty"`
+	TotalInTransitQty  float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_in_transit_qty"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+type ReceiveStockTransfer struct {
+	ReceiveItems []ReceiveStockTransferItem `json:"receive_items" validate:"required,min=1,dive,required"`
+}
+
+// ReceiveStockTransferItem takes what arrived, MissingQty closes the part of
+// the line that will never arrive (lost or broken on the way)
+type ReceiveStockTransferItem struct {
+	ID          uint    `json:"id" validate:"required"`
+	ReceivedQty float64 `json:"receive_qty" validate:"gte=0"`
+	MissingQty  float64 `json:"missing_qty" validate:"gte=0"`
+}
+
+// BeforeCreate numbers the transfer, only on create so later saves keep their number
+func (t *StockTransfer) BeforeCreate(tx *gorm.DB) error {
+
+	no, err := nextDocumentNo(tx, &StockTransfer{}, "T")
+	if err != nil {
+		return err
+	}
+	t.TransferNo = no
+
+	return nil
+}
+
+func (t *StockTransfer) stockSource() StockSource {
+	return StockSource{Type: "stock_transfers", Id: t.ID, No: t.TransferNo}
+}
+
+func validateStockTransfer(db *gorm.DB, input *StockTransfer) error {
+
+	if input.FromWarehouseId == input.ToWarehouseId {
+		return errors.New("source and destination warehouse must be different")
+	}
+	if _, err := getActiveWarehouse(db, input.FromWarehouseId); err != nil {
+		return err
+	}
+	if _, err := getActiveWarehouse(db, input.ToWarehouseId); err != nil {
+		return err
+	}
+
+	for _, item := range input.StockTransferItems {
+		if !helper.IsRecordValidByID(item.ProductVariationId, &ProductVariation{}, db) {
+			return errors.New("invalid product variation id")
+		}
+		if item.Qty <= 0 {
+			return errors.New("transfer qty must be greater than zero")
+		}
+	}
+	return nil
+}
+
+func buildStockTransferItems(items []StockTransferItem) ([]StockTransferItem, float64) {
+
+	var results []StockTransferItem
+	var totalQty float64
+
+	for _, item := range items {
+		results = append(results, StockTransferItem{
+			ProductVariationId: item.ProductVariationId,
+			Qty:                item.Qty,
+			ReceivedStatus:     Pending,
+		})
+		totalQty += item.Qty
+	}
+	return results, totalQty
+}
+
+func GetAllStockTransfers(c *gin.Context) ([]StockTransfer, error) {
+
+	var results []StockTransfer
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	search := c.Query("search")
+	status := c.Query("status")
+	fromWarehouseId := c.Query("from_warehouse_id")
+	toWarehouseId := c.Query("to_warehouse_id")
+
+	db := DB.Model(&StockTransfer{})
+
+	if search != "" {
+		db = db.Where("transfer_no LIKE ? OR note LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if fromWarehouseId != "" {
+		db = db.Where("from_warehouse_id = ?", fromWarehouseId)
+	}
+	if toWarehouseId != "" {
+		db = db.Where("to_warehouse_id = ?", toWarehouseId)
+	}
+
+	if err := utils.Paginate(db.Preload("FromWarehouse").Preload("ToWarehouse"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no stock transfers")
+	}
+
+	return results, nil
+}
+
+func GetStockTransfer(id uint64) (StockTransfer, error) {
+
+	var result StockTransfer
+
+	err := DB.Preload("FromWarehouse").
+			Preload("ToWarehouse").
+			Preload("StockTransferItems.ProductVariation").
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *StockTransfer) CreateStockTransfer(actor Actor) (*StockTransfer, error) {
+
+	if err := validateStockTransfer(DB, input); err != nil {
+		return &StockTransfer{}, err
+	}
+
+	items, totalQty := buildStockTransferItems(input.StockTransferItems)
+
+	input.StockTransferItems = items
+	input.Status = TransferDraft
+	input.TotalQty = totalQty
+	input.TotalReceivedQty = 0
+	input.TotalMissingQty = 0
+	input.TotalInTransitQty = 0
+	input.CreatedBy = actor.UserId
+	input.DispatchedBy = 0
+	input.DispatchedAt = nil
+	input.ReceivedAt = nil
+
+	if err := DB.Create(&input).Error; err != nil {
+		return &StockTransfer{}, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "stock_transfers", input.ID, nil, input)
+
+	return input, nil
+}
+
+// UpdateStockTransfer replaces the header and lines of a draft transfer
+func (input *StockTransfer) UpdateStockTransfer(id uint64, actor Actor) (*StockTransfer, error) {
+
+	tx := DB.Begin()
+
+	var existingTransfer StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockTransferItems").First(&existingTransfer, id).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, helper.ErrorRecordNotFound
+	}
+
+	if existingTransfer.Status != TransferDraft {
+		tx.Rollback()
+		return &StockTransfer{}, errors.New("only draft transfers can be changed")
+	}
+
+	if err := validateStockTransfer(tx, input); err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	before := existingTransfer
+
+	if err := tx.Where("stock_transfer_id = ?", id).Delete(&StockTransferItem{}).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	items, totalQty := buildStockTransferItems(input.StockTransferItems)
+
+	existingTransfer.FromWarehouseId = input.FromWarehouseId
+	existingTransfer.ToWarehouseId = input.ToWarehouseId
+	existingTransfer.Note = input.Note
+	existingTransfer.TotalQty = totalQty
+	existingTransfer.StockTransferItems = items
+
+	if err := tx.Omit("FromWarehouse", "ToWarehouse").Save(&existingTransfer).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	recordStockTransferAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockTransfer{}, err
+	}
+
+	return &existingTransfer, nil
+}
+
+// recordStockTransferAudit compares the transfer and its lines against the saved state
+func recordStockTransferAudit(tx *gorm.DB, actor Actor, before StockTransfer) {
+
+	var after StockTransfer
+	if err := tx.Preload("StockTransferItems").First(&after, before.ID).Error; err == nil {
+		recordAudit(tx, actor, AuditUpdate, "stock_transfers", after.ID, before, after)
+	}
+}
+
+// DispatchStockTransfer takes the goods out of the source warehouse, they
+// stay in transit until the destination receives them
+func DispatchStockTransfer(id uint64, actor Actor) (*StockTransfer, error) {
+
+	tx := DB.Begin()
+
+	var existingTransfer StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockTransferItems").First(&existingTransfer, id).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, helper.ErrorRecordNotFound
+	}
+
+	if existingTransfer.Status != TransferDraft {
+		tx.Rollback()
+		return &StockTransfer{}, errors.New("only draft transfers can be dispatched")
+	}
+
+	if _, err := getActiveWarehouse(tx, existingTransfer.FromWarehouseId); err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+	if _, err := getActiveWarehouse(tx, existingTransfer.ToWarehouseId); err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	before := existingTransfer
+
+	for i := range existingTransfer.StockTransferItems {
+		item := &existingTransfer.StockTransferItems[i]
+
+		if err := checkStockAvailable(tx, item.ProductVariationId, existingTransfer.FromWarehouseId, item.Qty); err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+
+		if err := recordStockMovement(tx, actor, existingTransfer.stockSource(), StockMovement{
+			ProductVariationId: item.ProductVariationId,
+			WarehouseId:        existingTransfer.FromWarehouseId,
+			Qty:                -item.Qty,
+			Reason:             ReasonTransfer,
+		}); err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+
+		item.TotalInTransitQty = item.Qty
+
+		if err := tx.Save(item).Error; err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+	}
+
+	now := time.Now()
+
+	existingTransfer.Status = TransferDispatched
+	existingTransfer.TotalInTransitQty = existingTransfer.TotalQty
+	existingTransfer.DispatchedBy = actor.UserId
+	existingTransfer.DispatchedAt = &now
+
+	if err := tx.Omit("StockTransferItems").Save(&existingTransfer).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	recordStockTransferAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockTransfer{}, err
+	}
+
+	return &existingTransfer, nil
+}
+
+// getTransferLotsInTransit is what left the source per lot and has not
+// arrived yet, so the goods keep their lot at the destination
+func getTransferLotsInTransit(tx *gorm.DB, transfer *StockTransfer, variationId uint) ([]lotQty, error) {
+
+	var results []lotQty
+
+	err := tx.Table("stock_movements").
+			Select("stock_movements.lot_id, "+
+				"SUM(CASE WHEN stock_movements.warehouse_id = ? AND stock_movements.qty < 0 THEN -stock_movements.qty ELSE 0 END) - "+
+				"SUM(CASE WHEN stock_movements.warehouse_id = ? AND stock_movements.qty > 0 THEN stock_movements.qty ELSE 0 END) AS qty",
+				transfer.FromWarehouseId, transfer.ToWarehouseId).
+			Joins("LEFT JOIN stock_lots ON stock_lots.id = stock_movements.lot_id").
+			Where("stock_movements.source_type = ? AND stock_movements.source_id = ?", "stock_transfers", transfer.ID).
+			Where("stock_movements.product_variation_id = ? AND stock_movements.reason = ?", variationId, ReasonTransfer).
+			Group("stock_movements.lot_id, stock_lots.expiry_date").
+			Order("stock_lots.expiry_date IS NULL, stock_lots.expiry_date, stock_movements.lot_id").
+			Scan(&results).Error
+
+	return results, err
+}
+
+// ReceiveStockTransfer books arrived goods into the destination, a missing
+// qty is booked in and written off as wastage so the loss shows in the ledger
+func (input *ReceiveStockTransfer) ReceiveStockTransfer(id uint64, actor Actor) (*StockTransfer, error) {
+
+	tx := DB.Begin()
+
+	var existingTransfer StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingTransfer, id).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, helper.ErrorRecordNotFound
+	}
+
+	var before StockTransfer
+	if err := tx.Preload("StockTransferItems").First(&before, id).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, helper.ErrorRecordNotFound
+	}
+
+	if existingTransfer.Status != TransferDispatched && existingTransfer.Status != TransferPartial {
+		tx.Rollback()
+		return &StockTransfer{}, errors.New("only dispatched transfers can be received")
+	}
+
+	source := existingTransfer.stockSource()
+
+	for _, receiveItem := range input.ReceiveItems {
+		var existingItem StockTransferItem
+
+		if err := tx.Where("id = ? AND stock_transfer_id = ?", receiveItem.ID, id).First(&existingItem).Error; err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+
+		if receiveItem.ReceivedQty < 0 || receiveItem.MissingQty < 0 || receiveItem.ReceivedQty+receiveItem.MissingQty <= 0 {
+			tx.Rollback()
+			return &StockTransfer{}, errors.New("receive qty must be greater than zero")
+		}
+
+		if receiveItem.ReceivedQty+receiveItem.MissingQty > existingItem.TotalInTransitQty {
+			tx.Rollback()
+			return &StockTransfer{}, errors.New("please enter receive qty less than in transit qty")
+		}
+
+		arrivedQty := receiveItem.ReceivedQty + receiveItem.MissingQty
+
+		inTransitLots, err := getTransferLotsInTransit(tx, &existingTransfer, existingItem.ProductVariationId)
+		if err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+		arrivedLots := allocateLots(inTransitLots, arrivedQty)
+
+		for _, lot := range arrivedLots {
+			if err := recordStockMovement(tx, actor, source, StockMovement{
+				ProductVariationId: existingItem.ProductVariationId,
+				WarehouseId:        existingTransfer.ToWarehouseId,
+				LotId:              lot.LotId,
+				Qty:                lot.Qty,
+				Reason:             ReasonTransfer,
+			}); err != nil {
+				tx.Rollback()
+				return &StockTransfer{}, err
+			}
+		}
+
+		if receiveItem.MissingQty > 0 {
+			note := fmt.Sprintf("missing on transfer %s", existingTransfer.TransferNo)
+			for _, lot := range allocateLots(arrivedLots, receiveItem.MissingQty) {
+				if err := recordStockMovement(tx, actor, source, StockMovement{
+					ProductVariationId: existingItem.ProductVariationId,
+					WarehouseId:        existingTransfer.ToWarehouseId,
+					LotId:              lot.LotId,
+					Qty:                -lot.Qty,
+					Reason:             ReasonWastage,
+					Note:               note,
+				}); err != nil {
+					tx.Rollback()
+					return &StockTransfer{}, err
+				}
+			}
+		}
+
+		existingItem.TotalReceivedQty += receiveItem.ReceivedQty
+		existingItem.TotalMissingQty += receiveItem.MissingQty
+		existingItem.TotalInTransitQty -= arrivedQty
+
+		if existingItem.TotalInTransitQty > 0 {
+			existingItem.ReceivedStatus = Partial
+		} else {
+			existingItem.ReceivedStatus = Complete
+		}
+
+		if err := tx.Save(&existingItem).Error; err != nil {
+			tx.Rollback()
+			return &StockTransfer{}, err
+		}
+
+		existingTransfer.TotalReceivedQty += receiveItem.ReceivedQty
+		existingTransfer.TotalMissingQty += receiveItem.MissingQty
+		existingTransfer.TotalInTransitQty -= arrivedQty
+	}
+
+	if existingTransfer.TotalInTransitQty > 0 {
+		existingTransfer.Status = TransferPartial
+	} else {
+		now := time.Now()
+		existingTransfer.Status = TransferReceived
+		existingTransfer.ReceivedAt = &now
+	}
+
+	if err := tx.Save(&existingTransfer).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	recordStockTransferAudit(tx, actor, before)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockTransfer{}, err
+	}
+
+	return &existingTransfer, nil
+}
+
+func CancelStockTransfer(id uint64, actor Actor) (*StockTransfer, error) {
+
+	tx := DB.Begin()
+
+	var existingTransfer StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockTransferItems").First(&existingTransfer, id).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, helper.ErrorRecordNotFound
+	}
+
+	if existingTransfer.Status != TransferDraft {
+		tx.Rollback()
+		return &StockTransfer{}, errors.New("only draft transfers can be cancelled")
+	}
+
+	if err := tx.Model(&StockTransfer{}).Where("id = ?", id).Update("status", TransferCancelled).Error; err != nil {
+		tx.Rollback()
+		return &StockTransfer{}, err
+	}
+
+	recordStockTransferAudit(tx, actor, existingTransfer)
+
+	if err := tx.Commit().Error; err != nil {
+		return &StockTransfer{}, err
+	}
+
+	existingTransfer.Status = TransferCancelled
+
+	return &existingTransfer, nil
+}
+
+func (input *StockTransfer) DeleteStockTransfer(id uint64, actor Actor) (*StockTransfer, error) {
+
+	tx := DB.Begin()
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("StockTransferItems").First(input, id).Error; err != nil {
+		tx.Rollback()
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if input.Status != TransferDraft && input.Status != TransferCancelled {
+		tx.Rollback()
+		return nil, errors.New("dispatched transfers cannot be deleted")
+	}
+
+	before := *input
+
+	if err := tx.Where("stock_transfer_id = ?", id).Delete(&StockTransferItem{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Delete(&input).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	recordAudit(tx, actor, AuditDelete, "stock_transfers", before.ID, before, nil)
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
+
+// getInTransitQtys sums what is on the road per variation
+func getInTransitQtys(variationIds []uint) (map[uint]float64, error) {
+
+	results := make(map[uint]float64)
+
+	if len(variationIds) == 0 {
+		return results, nil
+	}
+
+	var rows []struct {
+		ProductVariationId uint
+		InTransitQty       float64
+	}
+
+	err := DB.Table("stock_transfer_items").
+			Select("stock_transfer_items.product_variation_id, SUM(stock_transfer_items.total_in_transit_qty) AS in_transit_qty").
+			Joins("JOIN stock_transfers ON stock_transfers.id = stock_transfer_items.stock_transfer_id").
+			Where("stock_transfer_items.product_variation_id IN ?", variationIds).
+			Where("stock_transfers.status IN ? AND stock_transfers.deleted_at IS NULL", []TransferStatus{TransferDispatched, TransferPartial}).
+			Group("stock_transfer_items.product_variation_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		results[row.ProductVariationId] = row.InTransitQty
+	}
+	return results, nil
+}
@@ -0,0 +1,553 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StocktakeStatus string
+
+const (
+	StocktakeOpen      StocktakeStatus = "open"
+	StocktakeFinalized StocktakeStatus = "finalized"
+	StocktakeCancelled StocktakeStatus = "cancelled"
+)
+
+// Stocktake is a count session of one warehouse, optionally limited to a
+// category. SnapshotMovementId is the last ledger row when it started.
+// There is no bin subset, stock is kept per warehouse with no bins or
+// locations inside it to count or compare against
+type Stocktake struct {
+	ID                 uint             `gorm:"primary_key" json:"id"`
+	StocktakeNo        string           `gorm:"index;size:255;unique" json:"stocktake_no"`
+	Warehouse          *Warehouse       `gorm:"foreignKey:WarehouseId" json:"warehouse"`
+	WarehouseId        uint             `gorm:"index;not null" json:"warehouse_id" validate:"required"`
+	ProductCategory    *ProductCategory `gorm:"foreignKey:ProductCategoryId" json:"product_category"`
+	ProductCategoryId  *uint            `gorm:"index" json:"product_category_id"`
+	Status             StocktakeStatus  `gorm:"type:enum('open', 'finalized', 'cancelled');default:'open'" json:"status"`
+	Note               string           `gorm:"type:text" json:"note"`
+	SnapshotMovementId uint             `gorm:"not null;default:0" json:"snapshot_movement_id"`
+	TotalItemCount     uint             `gorm:"not null;default:0" json:"total_item_count"`
+	CountedItemCount   uint             `gorm:"not null;default:0" json:"counted_item_count"`
+	TotalVarianceQty   float64          `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_variance_qty"`
+	TotalVarianceValue float64          `gorm:"type:decimal(12,2);not null;default:0.0" json:"total_variance_value"`
+	StockAdjustmentId  *uint            `json:"stock_adjustment_id"`
+	StocktakeItems     []StocktakeItem  `json:"stocktake_items"`
+	CreatedBy          uint             `gorm:"not null;default:0" json:"created_by"`
+	FinalizedBy        uint             `gorm:"not null;default:0" json:"finalized_by"`
+	FinalizedAt        *time.Time       `json:"finalized_at"`
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt   `gorm:"index"`
+}
+
+// StocktakeItem compares the count against ExpectedQty, the on hand qty at
+// the moment of counting, so sales and receipts during the count are no variance
+type StocktakeItem struct {
+	ID                 uint              `gorm:"primary_key" json:"id"`
+	StocktakeId        uint              `gorm:"uniqueIndex:idx_stocktake_variation;not null" json:"stocktake_id"`
+	ProductVariation   *ProductVariation `gorm:"foreignKey:ProductVariationId" json:"product_variation"`
+	ProductVariationId uint              `gorm:"uniqueIndex:idx_stocktake_variation;not null" json:"product_variation_id"`
+	SnapshotQty        float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"snapshot_qty"`
+	ExpectedQty        float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"expected_qty"`
+	CountedQty         *float64          `gorm:"type:decimal(12,2)" json:"counted_qty"`
+	VarianceQty        float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"variance_qty"`
+	UnitCost           float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"unit_cost"`
+	VarianceValue      float64           `gorm:"type:decimal(12,2);not null;default:0.0" json:"variance_value"`
+	CountedBy          uint              `gorm:"not null;default:0" json:"counted_by"`
+	CountedAt          *time.Time        `json:"counted_at"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+type StocktakeCounts struct {
+	Counts []StocktakeCount `json:"counts" validate:"required,min=1,dive,required"`
+}
+
+// StocktakeCount identifies the item by id or scanned barcode, Add adds to
+// the counted qty (one scan per piece) instead of replacing it
+type StocktakeCount struct {
+	ProductVariationId uint    `json:"product_variation_id"`
+	Barcode            string  `json:"barcode"`
+	Qty                float64 `json:"qty" validate:"gte=0"`
+	Add                bool    `json:"add"`
+}
+
+// BeforeCreate numbers the stocktake, only on create so later saves keep their number
+func (s *Stocktake) BeforeCreate(tx *gorm.DB) error {
+
+	no, err := nextDocumentNo(tx, &Stocktake{}, "S")
+	if err != nil {
+		return err
+	}
+	s.StocktakeNo = no
+
+	return nil
+}
+
+// stocktakeScope lists the tracked variations a count session covers, the
+// whole warehouse or one category of it
+func stocktakeScope(db *gorm.DB, productCategoryId *uint) *gorm.DB {
+
+	scope := db.Table("product_variations").
+			Joins("JOIN products ON products.id = product_variations.product_id").
+			Where("products.is_qty_tracked = ? AND products.deleted_at IS NULL", true)
+
+	if productCategoryId != nil {
+		scope = scope.Where("products.product_category_id = ?", *productCategoryId)
+	}
+	return scope
+}
+
+// getSnapshotQtys sums the ledger up to and including snapshotMovementId
+func getSnapshotQtys(db *gorm.DB, warehouseId uint, snapshotMovementId uint, variationIds []uint) (map[uint]float64, error) {
+
+	results := make(map[uint]float64)
+
+	if len(variationIds) == 0 {
+		return results, nil
+	}
+
+	var rows []struct {
+		ProductVariationId uint
+		Qty                float64
+	}
+
+	err := db.Model(&StockMovement{}).
+			Select("product_variation_id, SUM(qty) AS qty").
+			Where("warehouse_id = ? AND id <= ? AND product_variation_id IN ?", warehouseId, snapshotMovementId, variationIds).
+			Group("product_variation_id").
+			Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+
+	for _, row := range rows {
+		results[row.ProductVariationId] = row.Qty
+	}
+	return results, nil
+}
+
+func GetAllStocktakes(c *gin.Context) ([]Stocktake, error) {
+
+	var results []Stocktake
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	status := c.Query("status")
+	warehouseId := c.Query("warehouse_id")
+
+	db := DB.Model(&Stocktake{})
+
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if warehouseId != "" {
+		db = db.Where("warehouse_id = ?", warehouseId)
+	}
+
+	if err := utils.Paginate(db.Preload("Warehouse").Preload("ProductCategory"), pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no stocktakes")
+	}
+
+	return results, nil
+}
+
+// GetStocktake returns the session with its variance lines, variance=true
+// leaves out the lines that were counted without a difference
+func GetStocktake(c *gin.Context, id uint64) (Stocktake, error) {
+
+	var result Stocktake
+
+	items := func(db *gorm.DB) *gorm.DB {
+		if c.Query("variance") == "true" {
+			db = db.Where("variance_qty <> 0")
+		}
+		if c.Query("uncounted") == "true" {
+			db = db.Where("counted_qty IS NULL")
+		}
+		return db
+	}
+
+	err := DB.Preload("Warehouse").
+			Preload("ProductCategory").
+			Preload("StocktakeItems", items).
+			Preload("StocktakeItems.ProductVariation").
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+// CreateStocktake starts a count session and snapshots the expected
+// quantities of every tracked variation in scope
+func (input *Stocktake) CreateStocktake(actor Actor) (*Stocktake, error) {
+
+	if _, err := getActiveWarehouse(DB, input.WarehouseId); err != nil {
+		return &Stocktake{}, err
+	}
+
+	if input.ProductCategoryId != nil && !helper.IsRecordValidByID(*input.ProductCategoryId, &ProductCategory{}, DB) {
+		return &Stocktake{}, errors.New("invalid product category id")
+	}
+
+	tx := DB.Begin()
+
+	var snapshotMovementId uint
+	if err := tx.Model(&StockMovement{}).Select("COALESCE(MAX(id), 0)").Scan(&snapshotMovementId).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	var variationIds []uint
+	if err := stocktakeScope(tx, input.ProductCategoryId).Pluck("product_variations.id", &variationIds).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	if len(variationIds) == 0 {
+		tx.Rollback()
+		return &Stocktake{}, errors.New("no tracked products to count")
+	}
+
+	snapshotQtys, err := getSnapshotQtys(tx, input.WarehouseId, snapshotMovementId, variationIds)
+	if err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	var items []StocktakeItem
+	for _, variationId := range variationIds {
+		items = append(items, StocktakeItem{
+			ProductVariationId: variationId,
+			SnapshotQty:        snapshotQtys[variationId],
+			ExpectedQty:        snapshotQtys[variationId],
+		})
+	}
+
+	input.Status = StocktakeOpen
+	input.SnapshotMovementId = snapshotMovementId
+	input.StocktakeItems = items
+	input.TotalItemCount = uint(len(items))
+	input.CountedItemCount = 0
+	input.TotalVarianceQty = 0
+	input.TotalVarianceValue = 0
+	input.StockAdjustmentId = nil
+	input.CreatedBy = actor.UserId
+	input.FinalizedBy = 0
+	input.FinalizedAt = nil
+
+	if err := tx.Create(&input).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	recordAudit(tx, actor, AuditCreate, "stocktakes", input.ID, nil, Stocktake{
+		ID:                 input.ID,
+		StocktakeNo:        input.StocktakeNo,
+		WarehouseId:        input.WarehouseId,
+		ProductCategoryId:  input.ProductCategoryId,
+		Status:             input.Status,
+		Note:               input.Note,
+		SnapshotMovementId: input.SnapshotMovementId,
+		TotalItemCount:     input.TotalItemCount,
+	})
+
+	if err := tx.Commit().Error; err != nil {
+		return &Stocktake{}, err
+	}
+
+	return input, nil
+}
+
+// findStocktakeItem resolves a count to its line, a scanned item that was
+// not in the snapshot is added when it belongs to the session's scope
+func findStocktakeItem(tx *gorm.DB, stocktake *Stocktake, count StocktakeCount) (StocktakeItem, error) {
+
+	var item StocktakeItem
+
+	variationId := count.ProductVariationId
+
+	if variationId == 0 {
+		if count.Barcode == "" {
+			return item, errors.New("product variation id or barcode is required")
+		}
+
+		var variation ProductVariation
+		if err := tx.Where("barcode = ?", count.Barcode).First(&variation).Error; err != nil {
+			return item, fmt.Errorf("unknown barcode %s", count.Barcode)
+		}
+		variationId = variation.ID
+	}
+
+	err := tx.Where("stocktake_id = ? AND product_variation_id = ?", stocktake.ID, variationId).Limit(1).Find(&item).Error
+	if err != nil {
+		return item, err
+	}
+	if item.ID != 0 {
+		return item, nil
+	}
+
+	var inScope int64
+	if err := stocktakeScope(tx, stocktake.ProductCategoryId).Where("product_variations.id = ?", variationId).Count(&inScope).Error; err != nil {
+		return item, err
+	}
+	if inScope == 0 {
+		return item, errors.New("item is not part of this stocktake")
+	}
+
+	snapshotQtys, err := getSnapshotQtys(tx, stocktake.WarehouseId, stocktake.SnapshotMovementId, []uint{variationId})
+	if err != nil {
+		return item, err
+	}
+
+	item = StocktakeItem{
+		StocktakeId:        stocktake.ID,
+		ProductVariationId: variationId,
+		SnapshotQty:        snapshotQtys[variationId],
+		ExpectedQty:        snapshotQtys[variationId],
+	}
+	if err := tx.Create(&item).Error; err != nil {
+		return item, err
+	}
+
+	return item, nil
+}
+
+// RecordStocktakeCounts saves counted quantities and works out the variance
+// against what the ledger says is on hand right now
+func (input *StocktakeCounts) RecordStocktakeCounts(id uint64, actor Actor) (*Stocktake, error) {
+
+	tx := DB.Begin()
+
+	var existingStocktake Stocktake
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingStocktake, id).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, helper.ErrorRecordNotFound
+	}
+
+	if existingStocktake.Status != StocktakeOpen {
+		tx.Rollback()
+		return &Stocktake{}, errors.New("stocktake is not open")
+	}
+
+	now := time.Now()
+
+	for _, count := range input.Counts {
+		if count.Qty < 0 {
+			tx.Rollback()
+			return &Stocktake{}, errors.New("counted qty cannot be negative")
+		}
+
+		item, err := findStocktakeItem(tx, &existingStocktake, count)
+		if err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+
+		countedQty := count.Qty
+		if count.Add && item.CountedQty != nil {
+			countedQty += *item.CountedQty
+		}
+
+		expectedQty, err := getWarehouseOnHandQty(tx, item.ProductVariationId, existingStocktake.WarehouseId)
+		if err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+
+		unitCost, err := getVariationUnitCost(tx, item.ProductVariationId)
+		if err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+
+		item.CountedQty = &countedQty
+		item.ExpectedQty = expectedQty
+		item.VarianceQty = countedQty - expectedQty
+		item.UnitCost = unitCost
+		item.VarianceValue = item.VarianceQty * unitCost
+		item.CountedBy = actor.UserId
+		item.CountedAt = &now
+
+		if err := tx.Save(&item).Error; err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+	}
+
+	if err := refreshStocktakeTotals(tx, &existingStocktake); err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &Stocktake{}, err
+	}
+
+	return &existingStocktake, nil
+}
+
+func refreshStocktakeTotals(tx *gorm.DB, stocktake *Stocktake) error {
+
+	var totals struct {
+		TotalItemCount     uint
+		CountedItemCount   uint
+		TotalVarianceQty   float64
+		TotalVarianceValue float64
+	}
+
+	err := tx.Model(&StocktakeItem{}).
+			Select("COUNT(*) AS total_item_count, COUNT(counted_qty) AS counted_item_count, " +
+				"COALESCE(SUM(variance_qty), 0) AS total_variance_qty, COALESCE(SUM(variance_value), 0) AS total_variance_value").
+			Where("stocktake_id = ?", stocktake.ID).
+			Scan(&totals).Error
+	if err != nil {
+		return err
+	}
+
+	stocktake.TotalItemCount = totals.TotalItemCount
+	stocktake.CountedItemCount = totals.CountedItemCount
+	stocktake.TotalVarianceQty = totals.TotalVarianceQty
+	stocktake.TotalVarianceValue = totals.TotalVarianceValue
+
+	return tx.Model(&Stocktake{}).Where("id = ?", stocktake.ID).Updates(map[string]interface{}{
+		"total_item_count":     totals.TotalItemCount,
+		"counted_item_count":   totals.CountedItemCount,
+		"total_variance_qty":   totals.TotalVarianceQty,
+		"total_variance_value": totals.TotalVarianceValue,
+	}).Error
+}
+
+// FinalizeStocktake turns the counted variances into one count correction
+// adjustment, it goes through the same approval threshold as any adjustment.
+// Lines that were never counted are left alone
+func FinalizeStocktake(id uint64, actor Actor) (*Stocktake, error) {
+
+	tx := DB.Begin()
+
+	var existingStocktake Stocktake
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingStocktake, id).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, helper.ErrorRecordNotFound
+	}
+
+	if existingStocktake.Status != StocktakeOpen {
+		tx.Rollback()
+		return &Stocktake{}, errors.New("stocktake is not open")
+	}
+
+	before := existingStocktake
+
+	var items []StocktakeItem
+	if err := tx.Where("stocktake_id = ? AND counted_qty IS NOT NULL AND variance_qty <> 0", id).Find(&items).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	if len(items) > 0 {
+		adjustment := StockAdjustment{
+			WarehouseId: existingStocktake.WarehouseId,
+			Status:      AdjustmentDraft,
+			Note:        fmt.Sprintf("stocktake %s", existingStocktake.StocktakeNo),
+			SourceType:  "stocktakes",
+			SourceId:    existingStocktake.ID,
+			CreatedBy:   actor.UserId,
+		}
+
+		for _, item := range items {
+			adjustment.StockAdjustmentItems = append(adjustment.StockAdjustmentItems, StockAdjustmentItem{
+				ProductVariationId: item.ProductVariationId,
+				Qty:                item.VarianceQty,
+				ReasonCode:         AdjustmentCountCorrection,
+				UnitCost:           item.UnitCost,
+				TotalValue:         item.VarianceValue,
+			})
+			adjustment.TotalValue += math.Abs(item.VarianceValue)
+		}
+
+		if err := tx.Create(&adjustment).Error; err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+
+		recordAudit(tx, actor, AuditCreate, "stock_adjustments", adjustment.ID, nil, adjustment)
+
+		if adjustment.needsApproval() {
+			adjustment.Status = AdjustmentPendingApproval
+			if err := tx.Omit("StockAdjustmentItems").Save(&adjustment).Error; err != nil {
+				tx.Rollback()
+				return &Stocktake{}, err
+			}
+		} else if err := postStockAdjustment(tx, actor, &adjustment); err != nil {
+			tx.Rollback()
+			return &Stocktake{}, err
+		}
+
+		existingStocktake.StockAdjustmentId = &adjustment.ID
+	}
+
+	now := time.Now()
+	existingStocktake.Status = StocktakeFinalized
+	existingStocktake.FinalizedBy = actor.UserId
+	existingStocktake.FinalizedAt = &now
+
+	if err := tx.Omit("StocktakeItems").Save(&existingStocktake).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	recordAudit(tx, actor, AuditUpdate, "stocktakes", existingStocktake.ID, before, existingStocktake)
+
+	if err := tx.Commit().Error; err != nil {
+		return &Stocktake{}, err
+	}
+
+	return &existingStocktake, nil
+}
+
+func CancelStocktake(id uint64, actor Actor) (*Stocktake, error) {
+
+	tx := DB.Begin()
+
+	var existingStocktake Stocktake
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existingStocktake, id).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, helper.ErrorRecordNotFound
+	}
+
+	if existingStocktake.Status != StocktakeOpen {
+		tx.Rollback()
+		return &Stocktake{}, errors.New("stocktake is not open")
+	}
+
+	before := existingStocktake
+
+	if err := tx.Model(&Stocktake{}).Where("id = ?", id).Update("status", StocktakeCancelled).Error; err != nil {
+		tx.Rollback()
+		return &Stocktake{}, err
+	}
+
+	existingStocktake.Status = StocktakeCancelled
+
+	recordAudit(tx, actor, AuditUpdate, "stocktakes", existingStocktake.ID, before, existingStocktake)
+
+	if err := tx.Commit().Error; err != nil {
+		return &Stocktake{}, err
+	}
+
+	return &existingStocktake, nil
+}
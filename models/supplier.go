@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
 	"gorm.io/gorm"
 )
 
@@ -25,20 +26,67 @@ func (result *Supplier) PrepareGive() {
 	result.Password = ""
 }
 
-func (input *Supplier) BeforeSave(*gorm.DB) error {
+// hashPassword hashes a newly given portal password, an empty one means
+// the supplier has no portal access or keeps the current password
+func (input *Supplier) hashPassword() error {
 
-	//turn password into hash
-	if input.Password != "" {
-		hashedPassword, err := Hash(input.Password)
-			if err != nil {
-				return err
-			}
-			input.Password = string(hashedPassword)
+	if input.Password == "" {
+		return nil
 	}
 
+	if err := helper.ValidatePasswordPolicy(input.Password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := Hash(input.Password)
+	if err != nil {
+		return err
+	}
+	input.Password = string(hashedPassword)
+
 	return nil
 }
 
+func SupplierLoginCheck(email string, password string, ip string) (string, error) {
+
+	var err error
+
+	keys := []string{"supplier:" + email, ipThrottleKey(ip)}
+
+	locked, err := isLoginLocked(keys...)
+	if err != nil {
+		return "", err
+	}
+	if locked {
+		return "", ErrorLoginLocked
+	}
+
+	s := Supplier{}
+
+	err = DB.Model(Supplier{}).Where("email = ?", email).Take(&s).Error
+
+	if err == nil && s.Password == "" {
+		err = errors.New("supplier has no portal access")
+	}
+
+	if err == nil {
+		err = VerifyPassword(password, s.Password)
+	}
+
+	if err != nil {
+		if err := recordLoginFailure(keys...); err != nil {
+			return "", err
+		}
+		return "", err
+	}
+
+	if err := clearLoginFailures(keys[0]); err != nil {
+		return "", err
+	}
+
+	return token.GenerateSupplierToken(s.ID, s.Name)
+}
+
 func GetAllSuppliers() ([]Supplier, error) {
 
 	var results []Supplier
@@ -69,7 +117,7 @@ func GetSupplier(id uint64) (Supplier, error) {
 	return result, nil
 }
 
-func (input *Supplier) CreateSupplier() (*Supplier, error) {
+func (input *Supplier) CreateSupplier(actor Actor) (*Supplier, error) {
 
 	var count int64
 
@@ -89,14 +137,21 @@ func (input *Supplier) CreateSupplier() (*Supplier, error) {
 		return &Supplier{}, errors.New("duplicate phone or email")
 	}
 
+	if err := input.hashPassword(); err != nil {
+		return &Supplier{}, err
+	}
+
 	err = DB.Create(&input).Error
 	if err != nil {
 		return &Supplier{}, err
 	}
+
+	recordAudit(DB, actor, AuditCreate, "suppliers", input.ID, nil, input)
+
 	return input, nil
 }
 
-func (input *Supplier) UpdateSupplier(id uint64) (*Supplier, error) {
+func (input *Supplier) UpdateSupplier(id uint64, actor Actor) (*Supplier, error) {
 
 	var count int64
 
@@ -108,17 +163,13 @@ func (input *Supplier) UpdateSupplier(id uint64) (*Supplier, error) {
         return &Supplier{}, errors.New("phone number validation error")
     } 
 
-	err := DB.Model(&Supplier{}).Where("id = ?", id).Count(&count).Error
-
-	if err != nil {
-		return &Supplier{}, err
-	}
+	var existingSupplier Supplier
 
-	if count <= 0 {
+	if err := DB.First(&existingSupplier, id).Error; err != nil {
 		return &Supplier{}, helper.ErrorRecordNotFound
 	}
 
-    if err = DB.Model(&Supplier{}).
+    if err := DB.Model(&Supplier{}).
         Where("email = ? OR phone = ?", input.Email, input.Phone).
         Not("id = ?", id).
         Count(&count).Error; err != nil {
@@ -129,25 +180,26 @@ func (input *Supplier) UpdateSupplier(id uint64) (*Supplier, error) {
         return nil, errors.New("duplicate email or phone")
     }
 
-	if input.Password != "" {
-		hashedPassword, err := Hash(input.Password)
-		if err != nil {
-			return nil, err
-		}
-		input.Password = string(hashedPassword)
+	if err := input.hashPassword(); err != nil {
+		return nil, err
 	}
 
-    err = DB.Model(&input).Where("id = ?", id).
+    err := DB.Model(&input).Where("id = ?", id).
         Updates(Supplier{Name: input.Name, Email: input.Email,Phone: input.Phone,Address: input.Address,Password: input.Password}).Error
 
     if err != nil {
         return nil, err
     }
 
+	var updatedSupplier Supplier
+	if err := DB.First(&updatedSupplier, id).Error; err == nil {
+		recordAudit(DB, actor, AuditUpdate, "suppliers", updatedSupplier.ID, existingSupplier, updatedSupplier)
+	}
+
     return input, nil
 }
 
-func (input *Supplier) DeleteSupplier(id uint64) (*Supplier, error) {
+func (input *Supplier) DeleteSupplier(id uint64, actor Actor) (*Supplier, error) {
 
 	err := DB.Model(&Supplier{}).Where("id = ?", id).First(&input).Error
 	if  err != nil {
@@ -158,5 +210,8 @@ func (input *Supplier) DeleteSupplier(id uint64) (*Supplier, error) {
 	if err != nil {
 		return &Supplier{}, err
 	}
+
+	recordAudit(DB, actor, AuditDelete, "suppliers", input.ID, input, nil)
+
 	return input, nil
 }
\ No newline at end of file
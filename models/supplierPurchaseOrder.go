@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+)
+
+type SupplierStatus string
+
+const (
+	SupplierStatusPending      SupplierStatus = "pending"
+	SupplierStatusAcknowledged SupplierStatus = "acknowledged"
+	SupplierStatusRejected     SupplierStatus = "rejected"
+)
+
+// supplierVisibleStatuses keeps drafts and orders waiting to be sent out
+// of the supplier portal
+var supplierVisibleStatuses = []PurchaseOrderStatus{PurchaseOrderSent, PurchaseOrderClosed, PurchaseOrderCancelled}
+
+type SupplierPurchaseOrderResponse struct {
+	ExpectedDeliveryDate string `json:"expected_delivery_date"`
+	Note                 string `json:"note"`
+}
+
+func GetSupplierPurchaseOrders(c *gin.Context, supplierId uint) ([]PurchaseOrder, error) {
+
+	var results []PurchaseOrder
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	sortBy := c.Query("sortBy")
+	orderBy := c.Query("orderBy")
+	supplierStatus := c.Query("supplier_status")
+
+	db := DB.Where("supplier_id = ? AND status IN ?", supplierId, supplierVisibleStatuses)
+
+	if supplierStatus != "" {
+		db = db.Where("supplier_status = ?", supplierStatus)
+	}
+
+	if err := utils.Paginate(db, pageParam, perPageParam, &results, sortBy, orderBy); err != nil {
+		return results, errors.New("no purchase orders")
+	}
+
+	return results, nil
+}
+
+func GetSupplierPurchaseOrder(id uint64, supplierId uint) (PurchaseOrder, error) {
+
+	var result PurchaseOrder
+
+	err := DB.Preload("Warehouse").
+			Preload("PurchaseOrderItems").
+			Where("supplier_id = ? AND status IN ?", supplierId, supplierVisibleStatuses).
+			First(&result, id).Error
+
+	if err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *SupplierPurchaseOrderResponse) AcknowledgePurchaseOrder(id uint64, supplierId uint) (*PurchaseOrder, error) {
+
+	if input.ExpectedDeliveryDate == "" {
+		return &PurchaseOrder{}, errors.New("expected delivery date is required")
+	}
+
+	expectedDeliveryDate, err := time.Parse("2006-01-02", input.ExpectedDeliveryDate)
+	if err != nil {
+		return &PurchaseOrder{}, errors.New("invalid expected delivery date")
+	}
+
+	return respondToPurchaseOrder(id, supplierId, SupplierStatusAcknowledged, &expectedDeliveryDate, input.Note)
+}
+
+func (input *SupplierPurchaseOrderResponse) RejectPurchaseOrder(id uint64, supplierId uint) (*PurchaseOrder, error) {
+
+	if input.Note == "" {
+		return &PurchaseOrder{}, errors.New("please give a reason for the rejection")
+	}
+
+	return respondToPurchaseOrder(id, supplierId, SupplierStatusRejected, nil, input.Note)
+}
+
+func respondToPurchaseOrder(id uint64, supplierId uint, status SupplierStatus, expectedDeliveryDate *time.Time, note string) (*PurchaseOrder, error) {
+
+	var existingPurchaseOrder PurchaseOrder
+
+	if err := DB.Where("supplier_id = ?", supplierId).First(&existingPurchaseOrder, id).Error; err != nil {
+		return &PurchaseOrder{}, helper.ErrorRecordNotFound
+	}
+
+	if existingPurchaseOrder.Status != PurchaseOrderSent {
+		return &PurchaseOrder{}, fmt.Errorf("this purchase order is %s", existingPurchaseOrder.Status)
+	}
+
+	if existingPurchaseOrder.SupplierStatus == SupplierStatusRejected {
+		return &PurchaseOrder{}, errors.New("this purchase order is already rejected")
+	}
+
+	if existingPurchaseOrder.ReceivedStatus == Complete {
+		return &PurchaseOrder{}, errors.New("this purchase order is already received")
+	}
+
+	now := time.Now()
+
+	// only the supplier's answer is written, the rest of the order stays as the buyer left it
+	err := DB.Model(&existingPurchaseOrder).UpdateColumns(map[string]interface{}{
+		"supplier_status":        status,
+		"expected_delivery_date": expectedDeliveryDate,
+		"supplier_note":          note,
+		"supplier_responded_at":  now,
+		"updated_at":             now,
+	}).Error
+	if err != nil {
+		return &PurchaseOrder{}, err
+	}
+
+	var updatedPurchaseOrder PurchaseOrder
+	if err := DB.First(&updatedPurchaseOrder, existingPurchaseOrder.ID).Error; err != nil {
+		return &PurchaseOrder{}, err
+	}
+
+	return &updatedPurchaseOrder, nil
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/token"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils/totp"
+	"gorm.io/gorm"
+)
+
+const RecoveryCodeCount = 10
+
+type RecoveryCode struct {
+	ID          uint       `gorm:"primary_key" json:"id"`
+	UserId      uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash    string     `gorm:"size:64;not null" json:"-"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+var (
+	ErrorTwoFactorCodeInvalid    = errors.New("invalid two factor code")
+	ErrorTwoFactorAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	ErrorTwoFactorNotEnabled     = errors.New("two factor authentication is not enabled")
+)
+
+func totpIssuer() string {
+
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "MKitchen"
+}
+
+// SetupTwoFactor stores a new pending secret, it only takes effect after
+// EnableTwoFactor confirms the user's app produces matching codes
+func SetupTwoFactor(userId uint) (*TwoFactorSetup, error) {
+
+	var user User
+
+	if err := DB.First(&user, userId).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if user.TotpEnabled {
+		return nil, ErrorTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := DB.Model(&user).UpdateColumn("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, user.Username, totpIssuer()),
+	}, nil
+}
+
+func EnableTwoFactor(userId uint, code string) ([]string, error) {
+
+	var user User
+
+	if err := DB.First(&user, userId).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if user.TotpEnabled {
+		return nil, ErrorTwoFactorAlreadyEnabled
+	}
+
+	if user.TotpSecret == "" {
+		return nil, errors.New("please set up two factor authentication first")
+	}
+
+	step, ok := totp.Validate(code, user.TotpSecret, time.Now())
+	if !ok {
+		return nil, ErrorTwoFactorCodeInvalid
+	}
+
+	tx := DB.Begin()
+
+	err := tx.Model(&user).UpdateColumns(map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func DisableTwoFactor(userId uint, password string, code string) error {
+
+	var user User
+
+	if err := DB.First(&user, userId).Error; err != nil {
+		return helper.ErrorRecordNotFound
+	}
+
+	if !user.TotpEnabled {
+		return ErrorTwoFactorNotEnabled
+	}
+
+	if err := VerifyPassword(password, user.Password); err != nil {
+		return ErrorCurrentPasswordIncorrect
+	}
+
+	if err := verifySecondFactor(&user, code); err != nil {
+		return err
+	}
+
+	tx := DB.Begin()
+
+	err := tx.Model(&user).UpdateColumns(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func RegenerateRecoveryCodes(userId uint, code string) ([]string, error) {
+
+	var user User
+
+	if err := DB.First(&user, userId).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	if !user.TotpEnabled {
+		return nil, ErrorTwoFactorNotEnabled
+	}
+
+	if err := verifySecondFactor(&user, code); err != nil {
+		return nil, err
+	}
+
+	tx := DB.Begin()
+
+	codes, err := replaceRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userId uint) ([]string, error) {
+
+	if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	var codes []string
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random, err := token.GenerateRandomToken()
+		if err != nil {
+			return nil, err
+		}
+		code := random[:5] + "-" + random[5:10]
+
+		if err := tx.Create(&RecoveryCode{UserId: userId, CodeHash: token.HashToken(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code,
+// a TOTP code is refused once its time step has been used
+func verifySecondFactor(user *User, code string) error {
+
+	code = strings.TrimSpace(code)
+
+	if step, ok := totp.Validate(code, user.TotpSecret, time.Now()); ok {
+		result := DB.Model(&User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			UpdateColumn("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrorTwoFactorCodeInvalid
+		}
+		return nil
+	}
+
+	result := DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, token.HashToken(strings.ToLower(code))).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrorTwoFactorCodeInvalid
+	}
+
+	return nil
+}
+
+// CompleteTwoFactorLogin finishes a login that LoginCheck answered with a challenge
+func CompleteTwoFactorLogin(challengeToken string, code string) (*AuthTokens, error) {
+
+	claims, err := token.ParseChallengeToken(challengeToken)
+	if err != nil {
+		return nil, ErrorTwoFactorCodeInvalid
+	}
+
+	key := fmt.Sprintf("2fa:%d", claims.UserID)
+
+	locked, err := isLoginLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, ErrorLoginLocked
+	}
+
+	var user User
+
+	if err := DB.First(&user, claims.UserID).Error; err != nil {
+		return nil, ErrorTwoFactorCodeInvalid
+	}
+
+	if !user.IsActive {
+		return nil, ErrorUserInactive
+	}
+
+	if !user.TotpEnabled {
+		return nil, ErrorTwoFactorNotEnabled
+	}
+
+	if err := verifySecondFactor(&user, code); err != nil {
+		if err == ErrorTwoFactorCodeInvalid {
+			if err := recordLoginFailure(key, usernameThrottleKey(user.Username)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := clearLoginFailures(key); err != nil {
+		return nil, err
+	}
+
+	return StartDeviceSession(user, claims.DeviceID, claims.DeviceName)
+}
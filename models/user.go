@@ -19,6 +19,11 @@ type User struct {
 	Email       string    `gorm:"size:255;unique" json:"email"`
 	Password    string    `gorm:"size:100;not null" json:"password"`
 	IsActive    bool     `gorm:"not null" json:"is_active"`
+	Role        Role      `gorm:"type:enum('owner', 'purchaser', 'warehouse_clerk', 'cashier', 'viewer');default:'viewer'" json:"role"`
+	TokensRevokedAt *time.Time `json:"-"`
+	TotpEnabled bool      `gorm:"not null;default:false" json:"totp_enabled"`
+	TotpSecret  string    `gorm:"size:64" json:"-"`
+	TotpLastStep int64    `gorm:"not null;default:0" json:"-"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -38,15 +43,21 @@ func VerifyPassword(password, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func (input *User) BeforeSave(*gorm.DB) error {
+// HashPassword replaces the plain Password with its hash, callers hash
+// explicitly so saving an already loaded user never hashes the hash again
+func (input *User) HashPassword() error {
 
-	//turn password into hash
 	hashedPassword, err := Hash(input.Password)
 	if err != nil {
 		return err
 	}
 	input.Password = string(hashedPassword)
 
+	return nil
+}
+
+func (input *User) BeforeSave(*gorm.DB) error {
+
 	if !input.IsActive {
     	input.IsActive = false
 	}
@@ -56,30 +67,67 @@ func (input *User) BeforeSave(*gorm.DB) error {
 	return nil
 }
 
-func LoginCheck(username string, password string) (string, error) {
+type LoginCredentials struct {
+	Username   string
+	Password   string
+	IP         string
+	DeviceId   string
+	DeviceName string
+}
+
+var ErrorUserInactive = errors.New("user account is deactivated")
+
+func LoginCheck(input LoginCredentials) (*AuthTokens, error) {
 
 	var err error
 
+	keys := []string{usernameThrottleKey(input.Username), ipThrottleKey(input.IP)}
+
+	locked, err := isLoginLocked(keys...)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, ErrorLoginLocked
+	}
+
 	u := User{}
 
-	err = DB.Model(User{}).Where("username = ?", username).Take(&u).Error
+	err = DB.Model(User{}).Where("username = ?", input.Username).Take(&u).Error
 
 	if err != nil {
-		return "", err
+		if err := recordLoginFailure(keys...); err != nil {
+			return nil, err
+		}
+		return nil, err
 	}
 
-	err = VerifyPassword(password, u.Password)
+	err = VerifyPassword(input.Password, u.Password)
 
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", err
+	if err != nil {
+		if err := recordLoginFailure(keys...); err != nil {
+			return nil, err
+		}
+		return nil, err
 	}
-	token, err := token.GenerateToken(u.ID, u.Username)
 
-	if err != nil {
-		return "", err
+	if !u.IsActive {
+		return nil, ErrorUserInactive
+	}
+
+	if err := clearLoginFailures(usernameThrottleKey(u.Username)); err != nil {
+		return nil, err
+	}
+
+	if u.TotpEnabled {
+		challengeToken, err := token.GenerateChallengeToken(u.ID, input.DeviceId, input.DeviceName)
+		if err != nil {
+			return nil, err
+		}
+		return &AuthTokens{TwoFactorRequired: true, ChallengeToken: challengeToken}, nil
 	}
 
-	return token, nil
+	return StartDeviceSession(u, input.DeviceId, input.DeviceName)
 
 }
 
@@ -98,7 +146,7 @@ func GetAllUsers() ([]User, error) {
 	return results, nil
 }
 
-func (input *User) CreateUser() (*User, error) {
+func (input *User) CreateUser(actor Actor) (*User, error) {
 
 	var count int64
 
@@ -106,6 +154,18 @@ func (input *User) CreateUser() (*User, error) {
 		return &User{}, errors.New("invalid email address")
 	}
 
+	if input.Role == "" {
+		input.Role = RoleViewer
+	}
+
+	// two factor is enrolled by the user themselves
+	input.TotpEnabled = false
+	input.TotpSecret = ""
+
+	if !IsValidRole(input.Role) {
+		return &User{}, errors.New("invalid role")
+	}
+
 	err := DB.Model(&User{}).Where("username = ?", input.Username).Or("email = ?", input.Email).Count(&count).Error
 	if err != nil {
 		return &User{}, err
@@ -114,10 +174,21 @@ func (input *User) CreateUser() (*User, error) {
 		return &User{}, errors.New("duplicate username or email")
 	}
 
+	if err := helper.ValidatePasswordPolicy(input.Password); err != nil {
+		return &User{}, err
+	}
+
+	if err := input.HashPassword(); err != nil {
+		return &User{}, err
+	}
+
 	err = DB.Create(&input).Error
 	if err != nil {
 		return &User{}, err
 	}
+
+	recordAudit(DB, actor, AuditCreate, "users", input.ID, nil, input)
+
 	return input, nil
 }
 
@@ -149,36 +220,153 @@ func GetUserByID(id uint) (User, error) {
 	return result, nil
 }
 
-func (input *User) UpdateUser(id uint64) (*User, error) {
+// UpdateUser holds the fields of a PATCH, a field left out of the request
+// stays nil and keeps its stored value
+type UpdateUser struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+	Username *string `json:"username"`
+	IsActive *bool   `json:"is_active"`
+	Role     *Role   `json:"role"`
+}
+
+func (input *UpdateUser) UpdateUser(id uint64, actor Actor) (*User, error) {
 
 	var count int64
 
-	err := DB.Model(&User{}).Where("id = ?", id).Count(&count).Error
-	if err != nil {
-		return &User{}, err
+	if input.Role != nil && !IsValidRole(*input.Role) {
+		return &User{}, errors.New("invalid role")
 	}
-	if count <= 0 {
+
+	var existingUser User
+	if err := DB.First(&existingUser, id).Error; err != nil {
         return nil, helper.ErrorRecordNotFound
     }
 
-	if err = DB.Model(&User{}).
-        Where("username = ? OR email = ?", input.Username, input.Email).
-        Not("id = ?", id).
-        Count(&count).Error; err != nil {
-        return nil, err
-    }
-	if count > 0 {
-		return &User{}, errors.New("duplicate email or username")
+	updates := map[string]interface{}{}
+
+	if input.Name != nil {
+		updates["name"] = *input.Name
+	}
+	if input.Email != nil {
+		updates["email"] = *input.Email
+	}
+	if input.Username != nil {
+		if *input.Username == "" {
+			return &User{}, errors.New("username cannot be empty")
+		}
+		updates["username"] = *input.Username
+	}
+	if input.IsActive != nil {
+		updates["is_active"] = *input.IsActive
+	}
+	if input.Role != nil {
+		updates["role"] = *input.Role
+
+		// permissions come from the role in the token, a changed role
+		// ends the tokens issued with the old one. A refresh issues the
+		// next token with the new role
+		if *input.Role != existingUser.Role {
+			updates["tokens_revoked_at"] = time.Now().Truncate(time.Second)
+		}
 	}
 
-	err = DB.Model(&input).Updates(User{Name: input.Name,Email: input.Email,Username: input.Username, IsActive: input.IsActive}).Error
+	if len(updates) == 0 {
+		return &existingUser, nil
+	}
+
+	if input.Username != nil || input.Email != nil {
+		username := existingUser.Username
+		if input.Username != nil {
+			username = *input.Username
+		}
+		email := existingUser.Email
+		if input.Email != nil {
+			email = *input.Email
+		}
+
+		if err := DB.Model(&User{}).
+			Where("username = ? OR email = ?", username, email).
+			Not("id = ?", id).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return &User{}, errors.New("duplicate email or username")
+		}
+	}
+
+	err := DB.Model(&User{}).Where("id = ?", id).Updates(updates).Error
 	if err != nil {
 		return &User{}, err
 	}
-	return input, nil
+
+	var updatedUser User
+	if err := DB.First(&updatedUser, id).Error; err != nil {
+		return &User{}, err
+	}
+
+	recordAudit(DB, actor, AuditUpdate, "users", updatedUser.ID, existingUser, updatedUser)
+
+	updatedUser.PrepareGive()
+
+	return &updatedUser, nil
+}
+
+// PromoteOwner makes the named user an active owner. A user that does not
+// exist yet is created with password, that is how a new install gets its
+// first owner
+func PromoteOwner(db *gorm.DB, username string, password string) (*User, error) {
+
+	var existingUser User
+
+	err := db.Where("username = ?", username).First(&existingUser).Error
+	if err == gorm.ErrRecordNotFound {
+		if password == "" {
+			return &User{}, errors.New("user not found, set OWNER_PASSWORD to create it")
+		}
+		if err := helper.ValidatePasswordPolicy(password); err != nil {
+			return &User{}, err
+		}
+
+		user := User{Username: username, Name: username, Password: password, IsActive: true, Role: RoleOwner}
+		if err := user.HashPassword(); err != nil {
+			return &User{}, err
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return &User{}, err
+		}
+
+		recordAudit(db, Actor{}, AuditCreate, "users", user.ID, nil, user)
+
+		user.PrepareGive()
+
+		return &user, nil
+	}
+	if err != nil {
+		return &User{}, err
+	}
+
+	before := existingUser
+
+	err = db.Model(&User{}).
+			Where("id = ?", existingUser.ID).
+			Updates(map[string]interface{}{"role": RoleOwner, "is_active": true}).Error
+	if err != nil {
+		return &User{}, err
+	}
+
+	existingUser.Role = RoleOwner
+	existingUser.IsActive = true
+
+	recordAudit(db, Actor{}, AuditUpdate, "users", existingUser.ID, before, existingUser)
+
+	existingUser.PrepareGive()
+
+	return &existingUser, nil
 }
 
-func (input *User) DeleteUser(id uint64) (*User, error) {
+func (input *User) DeleteUser(id uint64, actor Actor) (*User, error) {
 
 	err := DB.Model(&User{}).Where("id = ?", id).First(&input).Error
 	if  err != nil {
@@ -189,26 +377,56 @@ func (input *User) DeleteUser(id uint64) (*User, error) {
 	if err != nil {
 		return &User{}, err
 	}
+
+	recordAudit(DB, actor, AuditDelete, "users", input.ID, input, nil)
+
 	return input, nil
 }
 
 func (input *User) ChangeUserPassword() (*User, error) {
 
-	//turn password into hash
-	hashedPassword, err := Hash(input.Password)
-	if err != nil {
+	if err := helper.ValidatePasswordPolicy(input.Password); err != nil {
 		return &User{}, err
 	}
-	input.Password = string(hashedPassword)
 
-	err = DB.Model(&User{}).Where("id = ?", input.ID).First(&input).Error
-	if  err != nil {
+	var count int64
+
+	err := DB.Model(&User{}).Where("id = ?", input.ID).Count(&count).Error
+	if err != nil {
+		return &User{}, err
+	}
+	if count <= 0 {
         return nil, helper.ErrorRecordNotFound
     }
 
-	err = DB.Model(&input).Updates(User{Password: input.Password}).Error
+	if err := input.HashPassword(); err != nil {
+		return &User{}, err
+	}
+
+	err = DB.Model(&User{}).Where("id = ?", input.ID).UpdateColumn("password", input.Password).Error
 	if err != nil {
 		return &User{}, err
 	}
 	return input, nil
 }
+
+var ErrorCurrentPasswordIncorrect = errors.New("current password is incorrect")
+
+func ChangeOwnPassword(id uint, currentPassword string, newPassword string) error {
+
+	var user User
+
+	if err := DB.First(&user, id).Error; err != nil {
+		return helper.ErrorRecordNotFound
+	}
+
+	if err := VerifyPassword(currentPassword, user.Password); err != nil {
+		return ErrorCurrentPasswordIncorrect
+	}
+
+	input := User{ID: id, Password: newPassword}
+
+	_, err := input.ChangeUserPassword()
+
+	return err
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/helper"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/utils"
+	"gorm.io/gorm"
+)
+
+type WarehouseType string
+
+const (
+	WarehouseTypeWarehouse WarehouseType = "warehouse"
+	WarehouseTypeOutlet    WarehouseType = "outlet"
+)
+
+type Warehouse struct {
+	ID          uint           `gorm:"primary_key" json:"id"`
+	Name        string         `gorm:"size:255;not null;unique" json:"name" validate:"required,min=3,max=200"`
+	Code        string         `gorm:"size:50;not null;unique" json:"code" validate:"required,min=2,max=50"`
+	Type        WarehouseType  `gorm:"type:enum('warehouse', 'outlet');default:'warehouse'" json:"type"`
+	Address     string         `gorm:"type:text" json:"address"`
+	Phone       string         `gorm:"size:100" json:"phone"`
+	IsActive    bool           `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+type WarehouseStock struct {
+	WarehouseId   uint    `json:"warehouse_id"`
+	WarehouseName string  `json:"warehouse_name"`
+	OnHandQty     float64 `json:"on_hand_qty"`
+	ReservedQty   float64 `json:"reserved_qty"`
+	AvailableQty  float64 `json:"available_qty"`
+}
+
+type WarehouseStockItem struct {
+	ProductId          uint    `json:"product_id"`
+	Title              string  `json:"title"`
+	ProductVariationId uint    `json:"product_variation_id"`
+	VariantName        string  `json:"variant_name"`
+	SKU                string  `json:"sku"`
+	Barcode            string  `json:"barcode"`
+	OnHandQty          float64 `json:"on_hand_qty"`
+	ReservedQty        float64 `json:"reserved_qty"`
+	AvailableQty       float64 `json:"available_qty"`
+	StockValue         float64 `json:"stock_value"`
+}
+
+func (input *Warehouse) BeforeSave(*gorm.DB) error {
+	//remove spaces
+	input.Name = html.EscapeString(strings.TrimSpace(input.Name))
+	input.Code = html.EscapeString(strings.TrimSpace(input.Code))
+
+	return nil
+}
+
+func isValidWarehouseType(warehouseType WarehouseType) bool {
+
+	switch warehouseType {
+	case WarehouseTypeWarehouse, WarehouseTypeOutlet:
+		return true
+	}
+	return false
+}
+
+// getActiveWarehouse loads a warehouse stock can be moved in or out of
+func getActiveWarehouse(db *gorm.DB, id uint) (Warehouse, error) {
+
+	var result Warehouse
+
+	if err := db.First(&result, id).Error; err != nil {
+		return result, errors.New("invalid warehouse id")
+	}
+	if !result.IsActive {
+		return result, errors.New("warehouse is not active")
+	}
+	return result, nil
+}
+
+func GetAllWarehouses(c *gin.Context) ([]Warehouse, error) {
+
+	var results []Warehouse
+
+	pageParam := c.Query("page")
+	perPageParam := c.Query("perPage")
+	search := c.Query("search")
+	warehouseType := c.Query("type")
+
+	db := DB.Model(&Warehouse{})
+
+	if search != "" {
+		db = db.Where("name LIKE ? OR code LIKE ?", "%"+search+"%", "%"+search+"%")
+	}
+	if warehouseType != "" {
+		db = db.Where("type = ?", warehouseType)
+	}
+
+	if err := utils.Paginate(db, pageParam, perPageParam, &results, "", ""); err != nil {
+		return results, errors.New("no warehouses")
+	}
+	return results, nil
+}
+
+func GetWarehouse(id uint64) (Warehouse, error) {
+
+	var result Warehouse
+
+	if err := DB.First(&result, id).Error; err != nil {
+		return result, helper.ErrorRecordNotFound
+	}
+
+	return result, nil
+}
+
+func (input *Warehouse) CreateWarehouse(actor Actor) (*Warehouse, error) {
+
+	if input.Type == "" {
+		input.Type = WarehouseTypeWarehouse
+	}
+	if !isValidWarehouseType(input.Type) {
+		return &Warehouse{}, errors.New("invalid warehouse type")
+	}
+
+	var count int64
+
+	err := DB.Model(&Warehouse{}).Where("name = ? OR code = ?", input.Name, input.Code).Count(&count).Error
+	if err != nil {
+		return &Warehouse{}, err
+	}
+	if count > 0 {
+		return &Warehouse{}, errors.New("duplicate name or code")
+	}
+
+	err = DB.Create(&input).Error
+	if err != nil {
+		return &Warehouse{}, err
+	}
+
+	recordAudit(DB, actor, AuditCreate, "warehouses", input.ID, nil, input)
+
+	return input, nil
+}
+
+// UpdateWarehouse holds the fields of a PATCH, a field left out of the
+// request stays nil and keeps its stored value
+type UpdateWarehouse struct {
+	Name     *string        `json:"name" validate:"omitnil,min=3,max=200"`
+	Code     *string        `json:"code" validate:"omitnil,min=2,max=50"`
+	Type     *WarehouseType `json:"type"`
+	Address  *string        `json:"address"`
+	Phone    *string        `json:"phone"`
+	IsActive *bool          `json:"is_active"`
+}
+
+func (input *UpdateWarehouse) UpdateWarehouse(id uint64, actor Actor) (*Warehouse, error) {
+
+	if input.Type != nil && !isValidWarehouseType(*input.Type) {
+		return &Warehouse{}, errors.New("invalid warehouse type")
+	}
+
+	var existingWarehouse Warehouse
+
+	if err := DB.First(&existingWarehouse, id).Error; err != nil {
+		return &Warehouse{}, helper.ErrorRecordNotFound
+	}
+
+	updates := map[string]interface{}{}
+
+	if input.Name != nil {
+		updates["name"] = html.EscapeString(strings.TrimSpace(*input.Name))
+	}
+	if input.Code != nil {
+		updates["code"] = html.EscapeString(strings.TrimSpace(*input.Code))
+	}
+	if input.Type != nil {
+		updates["type"] = *input.Type
+	}
+	if input.Address != nil {
+		updates["address"] = *input.Address
+	}
+	if input.Phone != nil {
+		updates["phone"] = *input.Phone
+	}
+	if input.IsActive != nil {
+		updates["is_active"] = *input.IsActive
+	}
+
+	if len(updates) == 0 {
+		return &existingWarehouse, nil
+	}
+
+	if input.Name != nil || input.Code != nil {
+		var count int64
+
+		name, _ := updates["name"].(string)
+		code, _ := updates["code"].(string)
+
+		if err := DB.Model(&Warehouse{}).
+			Where("name = ? OR code = ?", name, code).
+			Not("id = ?", id).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("duplicate name or code")
+		}
+	}
+
+	if err := DB.Model(&Warehouse{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	var updatedWarehouse Warehouse
+	if err := DB.First(&updatedWarehouse, id).Error; err != nil {
+		return nil, err
+	}
+
+	recordAudit(DB, actor, AuditUpdate, "warehouses", updatedWarehouse.ID, existingWarehouse, updatedWarehouse)
+
+	return &updatedWarehouse, nil
+}
+
+func (input *Warehouse) DeleteWarehouse(id uint64, actor Actor) (*Warehouse, error) {
+
+	if err := DB.First(&input, id).Error; err != nil {
+		return nil, helper.ErrorRecordNotFound
+	}
+
+	var stockedVariationIds []uint
+
+	err := DB.Model(&StockMovement{}).
+			Where("warehouse_id = ?", id).
+			Group("product_variation_id").
+			Having("SUM(qty) <> 0").
+			Pluck("product_variation_id", &stockedVariationIds).Error
+	if err != nil {
+		return &Warehouse{}, err
+	}
+	if len(stockedVariationIds) > 0 {
+		return &Warehouse{}, errors.New("warehouse still has stock on hand")
+	}
+
+	if err := DB.Delete(&input).Error; err != nil {
+		return &Warehouse{}, err
+	}
+
+	recordAudit(DB, actor, AuditDelete, "warehouses", input.ID, input, nil)
+
+	return input, nil
+}
+
+// GetWarehouseStock reports what is on hand at one location
+func GetWarehouseStock(c *gin.Context, id uint64) ([]WarehouseStockItem, error) {
+
+	results := []WarehouseStockItem{}
+
+	if !helper.IsRecordValidByID(uint(id), &Warehouse{}, DB) {
+		return results, helper.ErrorRecordNotFound
+	}
+
+	search := c.Query("search")
+	productId := c.Query("product_id")
+	includeEmpty := c.Query("include_empty") == "true"
+
+	db := DB.Table("stock_movements").
+			Select("products.id AS product_id, products.title, product_variations.id AS product_variation_id, " +
+				"product_variations.variant_name, product_variations.sku, product_variations.barcode, " +
+				"SUM(stock_movements.qty) AS on_hand_qty, SUM(stock_movements.qty * stock_movements.unit_cost) AS stock_value").
+			Joins("JOIN product_variations ON product_variations.id = stock_movements.product_variation_id").
+			Joins("JOIN products ON products.id = product_variations.product_id").
+			Where("stock_movements.warehouse_id = ?", id)
+
+	if search != "" {
+		db = db.Where("products.title LIKE ? OR product_variations.sku LIKE ? OR product_variations.barcode LIKE ?",
+			"%"+search+"%", "%"+search+"%", "%"+search+"%")
+	}
+	if productId != "" {
+		db = db.Where("products.id = ?", productId)
+	}
+
+	db = db.Group("products.id, products.title, product_variations.id, product_variations.variant_name, " +
+		"product_variations.sku, product_variations.barcode")
+
+	if !includeEmpty {
+		db = db.Having("SUM(stock_movements.qty) <> 0")
+	}
+
+	if err := db.Order("products.title, product_variations.variant_name").Scan(&results).Error; err != nil {
+		return results, errors.New("error fetching stock")
+	}
+
+	var variationIds []uint
+	for _, result := range results {
+		variationIds = append(variationIds, result.ProductVariationId)
+	}
+
+	reservedQtys, err := getReservedQtys(variationIds)
+	if err != nil {
+		return results, errors.New("error fetching stock")
+	}
+
+	for i := range results {
+		results[i].ReservedQty = reservedQtys[results[i].ProductVariationId][uint(id)]
+		results[i].AvailableQty = results[i].OnHandQty - results[i].ReservedQty
+	}
+
+	return results, nil
+}
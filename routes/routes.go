@@ -5,7 +5,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/controllers/admin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/controllers/supplier"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend/middlewares"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend/models"
 )
 
 func SetupRoutes(r *gin.Engine) {
@@ -13,47 +15,167 @@ func SetupRoutes(r *gin.Engine) {
 	r.GET("", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, "welcome home")
 	})
+	r.GET("/.well-known/jwks.json", admin.GetJWKS)
+
 	authRouter := r.Group("/api/v1")
 	authRouter.POST("/login", admin.Login)
+	authRouter.POST("/login/2fa", admin.LoginTwoFactor)
+	authRouter.POST("/token/refresh", admin.RefreshToken)
+	authRouter.POST("/password/reset", admin.ResetPassword)
+	authRouter.POST("/supplier/login", supplier.Login)
 
 	protectedRouter := r.Group("/api/v1")
 	protectedRouter.Use(middlewares.JwtAuthMiddleware())
 
-	protectedRouter.GET("/profile", admin.CurrentUser)
-	protectedRouter.POST("/logout", admin.Logout)
-
-	protectedRouter.GET("/users", admin.GetAllUsers)
-	protectedRouter.POST("/users", admin.CreateUser)
-	protectedRouter.PATCH("/users/:id", admin.UpdateUser)
-	protectedRouter.DELETE("/users/:id", admin.DeleteUser)
-	protectedRouter.GET("/users/:id", admin.GetUser)
-
-	protectedRouter.GET("/product_categories", admin.GetAllProductCategories)
-	protectedRouter.POST("/product_categories", admin.CreateProductCategory)
-	protectedRouter.PATCH("/product_categories/:id", admin.UpdateProductCategory)
-	protectedRouter.DELETE("/product_categories/:id", admin.DeleteProductCategory)
-	protectedRouter.GET("/product_categories/:id", admin.GetProductCategory)
-
-	protectedRouter.GET("/suppliers", admin.GetAllSuppliers)
-	protectedRouter.POST("/suppliers", admin.CreateSupplier)
-	protectedRouter.PATCH("/suppliers/:id", admin.UpdateSupplier)
-	protectedRouter.DELETE("/suppliers/:id", admin.DeleteSupplier)
-	protectedRouter.GET("/suppliers/:id", admin.GetSupplier)
-
-	protectedRouter.GET("/products", admin.GetAllProducts)
-	protectedRouter.POST("/products", admin.CreateProduct)
-	protectedRouter.PATCH("/products/:id", admin.UpdateProduct)
-	protectedRouter.DELETE("/products/:id", admin.DeleteProduct)
-	protectedRouter.GET("/products/:id", admin.GetProduct)
-
-	protectedRouter.POST("/upload_image", admin.UploadImage)
-	protectedRouter.DELETE("/delete_image/:id", admin.DeleteImage)
-
-	protectedRouter.GET("/purchase_orders", admin.GetAllPurchaseOrders)
-	protectedRouter.POST("/purchase_orders", admin.CreatePurchaseOrder)
-	protectedRouter.PATCH("/purchase_orders/:id", admin.UpdatePurchaseOrder)
-	protectedRouter.DELETE("/purchase_orders/:id", admin.DeletePurchaseOrder)
-	protectedRouter.GET("/purchase_orders/:id", admin.GetPurchaseOrder)
-
-	protectedRouter.POST("/purchase_orders/:id/receive", admin.ReceivePurchaseOrder)
-}
\ No newline at end of file
+	can := middlewares.PermissionMiddleware
+
+	// routes without a permission act for the signed in user
+	userRouter := protectedRouter.Group("")
+	userRouter.Use(middlewares.UserOnlyMiddleware())
+
+	userRouter.GET("/profile", admin.CurrentUser)
+	userRouter.POST("/profile/password", admin.ChangePassword)
+	userRouter.POST("/profile/2fa/setup", admin.SetupTwoFactor)
+	userRouter.POST("/profile/2fa/enable", admin.EnableTwoFactor)
+	userRouter.POST("/profile/2fa/disable", admin.DisableTwoFactor)
+	userRouter.POST("/profile/2fa/recovery_codes", admin.RegenerateRecoveryCodes)
+	userRouter.POST("/logout", admin.Logout)
+	userRouter.POST("/logout_all", admin.LogoutAll)
+	userRouter.GET("/devices", admin.GetDevices)
+	userRouter.DELETE("/devices/:device_id", admin.RevokeDevice)
+
+	userRouter.GET("/notifications", admin.GetAllNotifications)
+	userRouter.GET("/notifications/unread_count", admin.CountUnreadNotifications)
+	userRouter.POST("/notifications/read_all", admin.ReadAllNotifications)
+	userRouter.POST("/notifications/:id/read", admin.ReadNotification)
+	userRouter.POST("/notifications/:id/unread", admin.UnreadNotification)
+
+	protectedRouter.GET("/users", can(models.PermUsersManage), admin.GetAllUsers)
+	protectedRouter.POST("/users", can(models.PermUsersManage), admin.CreateUser)
+	protectedRouter.PATCH("/users/:id", can(models.PermUsersManage), admin.UpdateUser)
+	protectedRouter.DELETE("/users/:id", can(models.PermUsersManage), admin.DeleteUser)
+	protectedRouter.GET("/users/:id", can(models.PermUsersManage), admin.GetUser)
+	protectedRouter.POST("/users/:id/logout_all", can(models.PermUsersManage), admin.RevokeUserSessions)
+	protectedRouter.POST("/users/:id/unlock", can(models.PermUsersManage), admin.UnlockUser)
+	protectedRouter.POST("/users/:id/password_reset", can(models.PermUsersManage), admin.CreatePasswordReset)
+
+	protectedRouter.GET("/login_throttles", can(models.PermUsersManage), admin.GetAllLoginThrottles)
+	protectedRouter.DELETE("/login_throttles/:id", can(models.PermUsersManage), admin.DeleteLoginThrottle)
+
+	protectedRouter.GET("/api_keys", can(models.PermAPIKeysManage), admin.GetAllAPIKeys)
+	protectedRouter.POST("/api_keys", can(models.PermAPIKeysManage), admin.CreateAPIKey)
+	protectedRouter.DELETE("/api_keys/:id", can(models.PermAPIKeysManage), admin.RevokeAPIKey)
+	protectedRouter.GET("/api_keys/:id", can(models.PermAPIKeysManage), admin.GetAPIKey)
+
+	protectedRouter.GET("/audit_logs", can(models.PermAuditLogsRead), admin.GetAllAuditLogs)
+
+	protectedRouter.GET("/product_categories", can(models.PermCatalogRead), admin.GetAllProductCategories)
+	protectedRouter.POST("/product_categories", can(models.PermCatalogWrite), admin.CreateProductCategory)
+	protectedRouter.PATCH("/product_categories/:id", can(models.PermCatalogWrite), admin.UpdateProductCategory)
+	protectedRouter.DELETE("/product_categories/:id", can(models.PermCatalogWrite), admin.DeleteProductCategory)
+	protectedRouter.GET("/product_categories/:id", can(models.PermCatalogRead), admin.GetProductCategory)
+
+	protectedRouter.GET("/warehouses", can(models.PermWarehousesRead), admin.GetAllWarehouses)
+	protectedRouter.POST("/warehouses", can(models.PermWarehousesWrite), admin.CreateWarehouse)
+	protectedRouter.PATCH("/warehouses/:id", can(models.PermWarehousesWrite), admin.UpdateWarehouse)
+	protectedRouter.DELETE("/warehouses/:id", can(models.PermWarehousesWrite), admin.DeleteWarehouse)
+	protectedRouter.GET("/warehouses/:id", can(models.PermWarehousesRead), admin.GetWarehouse)
+	protectedRouter.GET("/warehouses/:id/stock", can(models.PermWarehousesRead), admin.GetWarehouseStock)
+	protectedRouter.GET("/stock_lots/expiring", can(models.PermWarehousesRead), admin.GetExpiringLots)
+
+	protectedRouter.GET("/reorder_points", can(models.PermWarehousesRead), admin.GetAllReorderPoints)
+	protectedRouter.POST("/reorder_points", can(models.PermWarehousesWrite), admin.CreateReorderPoint)
+	protectedRouter.PATCH("/reorder_points/:id", can(models.PermWarehousesWrite), admin.UpdateReorderPoint)
+	protectedRouter.DELETE("/reorder_points/:id", can(models.PermWarehousesWrite), admin.DeleteReorderPoint)
+	protectedRouter.GET("/reorder_points/:id", can(models.PermWarehousesRead), admin.GetReorderPoint)
+
+	protectedRouter.GET("/stock_transfers", can(models.PermStockTransfersRead), admin.GetAllStockTransfers)
+	protectedRouter.POST("/stock_transfers", can(models.PermStockTransfersWrite), admin.CreateStockTransfer)
+	protectedRouter.PATCH("/stock_transfers/:id", can(models.PermStockTransfersWrite), admin.UpdateStockTransfer)
+	protectedRouter.DELETE("/stock_transfers/:id", can(models.PermStockTransfersWrite), admin.DeleteStockTransfer)
+	protectedRouter.GET("/stock_transfers/:id", can(models.PermStockTransfersRead), admin.GetStockTransfer)
+	protectedRouter.POST("/stock_transfers/:id/dispatch", can(models.PermStockTransfersWrite), admin.DispatchStockTransfer)
+	protectedRouter.POST("/stock_transfers/:id/receive", can(models.PermStockTransfersWrite), admin.ReceiveStockTransfer)
+	protectedRouter.POST("/stock_transfers/:id/cancel", can(models.PermStockTransfersWrite), admin.CancelStockTransfer)
+
+	protectedRouter.GET("/stock_adjustments", can(models.PermStockAdjustmentsRead), admin.GetAllStockAdjustments)
+	protectedRouter.POST("/stock_adjustments", can(models.PermStockAdjustmentsWrite), admin.CreateStockAdjustment)
+	protectedRouter.PATCH("/stock_adjustments/:id", can(models.PermStockAdjustmentsWrite), admin.UpdateStockAdjustment)
+	protectedRouter.DELETE("/stock_adjustments/:id", can(models.PermStockAdjustmentsWrite), admin.DeleteStockAdjustment)
+	protectedRouter.GET("/stock_adjustments/:id", can(models.PermStockAdjustmentsRead), admin.GetStockAdjustment)
+	protectedRouter.POST("/stock_adjustments/:id/submit", can(models.PermStockAdjustmentsWrite), admin.SubmitStockAdjustment)
+	protectedRouter.POST("/stock_adjustments/:id/approve", can(models.PermStockAdjustmentsApprove), admin.ApproveStockAdjustment)
+	protectedRouter.POST("/stock_adjustments/:id/reject", can(models.PermStockAdjustmentsApprove), admin.RejectStockAdjustment)
+
+	protectedRouter.GET("/stocktakes", can(models.PermStocktakesRead), admin.GetAllStocktakes)
+	protectedRouter.POST("/stocktakes", can(models.PermStocktakesWrite), admin.CreateStocktake)
+	protectedRouter.GET("/stocktakes/:id", can(models.PermStocktakesRead), admin.GetStocktake)
+	protectedRouter.POST("/stocktakes/:id/counts", can(models.PermStocktakesWrite), admin.RecordStocktakeCounts)
+	protectedRouter.POST("/stocktakes/:id/finalize", can(models.PermStocktakesWrite), admin.FinalizeStocktake)
+	protectedRouter.POST("/stocktakes/:id/cancel", can(models.PermStocktakesWrite), admin.CancelStocktake)
+
+	protectedRouter.GET("/stock_reservations", can(models.PermStockReservationsRead), admin.GetAllStockReservations)
+	protectedRouter.POST("/stock_reservations", can(models.PermStockReservationsWrite), admin.CreateStockReservations)
+	protectedRouter.GET("/stock_reservations/:id", can(models.PermStockReservationsRead), admin.GetStockReservation)
+	protectedRouter.POST("/stock_reservations/:id/release", can(models.PermStockReservationsWrite), admin.ReleaseStockReservation)
+	protectedRouter.POST("/stock_reservations/:id/fulfil", can(models.PermStockReservationsWrite), admin.FulfilStockReservation)
+
+	protectedRouter.GET("/suppliers", can(models.PermSuppliersRead), admin.GetAllSuppliers)
+	protectedRouter.POST("/suppliers", can(models.PermSuppliersWrite), admin.CreateSupplier)
+	protectedRouter.PATCH("/suppliers/:id", can(models.PermSuppliersWrite), admin.UpdateSupplier)
+	protectedRouter.DELETE("/suppliers/:id", can(models.PermSuppliersDelete), admin.DeleteSupplier)
+	protectedRouter.GET("/suppliers/:id", can(models.PermSuppliersRead), admin.GetSupplier)
+
+	protectedRouter.GET("/products", can(models.PermCatalogRead), admin.GetAllProducts)
+	protectedRouter.POST("/products", can(models.PermCatalogWrite), admin.CreateProduct)
+	protectedRouter.PATCH("/products/:id", can(models.PermCatalogWrite), admin.UpdateProduct)
+	protectedRouter.DELETE("/products/:id", can(models.PermCatalogWrite), admin.DeleteProduct)
+	protectedRouter.GET("/products/:id", can(models.PermCatalogRead), admin.GetProduct)
+	protectedRouter.GET("/products/:id/stock", can(models.PermCatalogRead), admin.GetProductStock)
+	protectedRouter.GET("/product_variations/:id/stock_movements", can(models.PermCatalogRead), admin.GetStockMovements)
+	protectedRouter.GET("/product_variations/:id/lots", can(models.PermCatalogRead), admin.GetVariationLots)
+
+	protectedRouter.POST("/upload_image", can(models.PermCatalogWrite), admin.UploadImage)
+	protectedRouter.DELETE("/delete_image/:id", can(models.PermCatalogWrite), admin.DeleteImage)
+
+	protectedRouter.GET("/purchase_orders", can(models.PermPurchaseOrdersRead), admin.GetAllPurchaseOrders)
+	protectedRouter.GET("/purchase_orders/suggestions", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderSuggestions)
+	protectedRouter.POST("/purchase_orders/suggestions/convert", can(models.PermPurchaseOrdersWrite), admin.ConvertPurchaseOrderSuggestion)
+	protectedRouter.POST("/purchase_orders", can(models.PermPurchaseOrdersWrite), admin.CreatePurchaseOrder)
+	protectedRouter.PATCH("/purchase_orders/:id", can(models.PermPurchaseOrdersWrite), admin.UpdatePurchaseOrder)
+	protectedRouter.DELETE("/purchase_orders/:id", can(models.PermPurchaseOrdersDelete), admin.DeletePurchaseOrder)
+	protectedRouter.GET("/purchase_orders/:id", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrder)
+	protectedRouter.GET("/purchase_orders/:id/pdf", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderPdf)
+
+	protectedRouter.POST("/purchase_orders/:id/receive", can(models.PermPurchaseOrdersReceive), admin.ReceivePurchaseOrder)
+	protectedRouter.POST("/purchase_orders/:id/approve", can(models.PermPurchaseOrdersApprove), admin.ApprovePurchaseOrder)
+	protectedRouter.POST("/purchase_orders/:id/send", can(models.PermPurchaseOrdersWrite), admin.SendPurchaseOrder)
+	protectedRouter.POST("/purchase_orders/:id/cancel", can(models.PermPurchaseOrdersWrite), admin.CancelPurchaseOrder)
+	protectedRouter.POST("/purchase_orders/:id/close", can(models.PermPurchaseOrdersWrite), admin.ClosePurchaseOrder)
+	protectedRouter.POST("/purchase_orders/:id/email", can(models.PermPurchaseOrdersWrite), admin.EmailPurchaseOrder)
+	protectedRouter.GET("/purchase_orders/:id/emails", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderEmails)
+	protectedRouter.GET("/purchase_orders/:id/goods_received_notes", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderGoodsReceivedNotes)
+	protectedRouter.GET("/goods_received_notes/:id", can(models.PermPurchaseOrdersRead), admin.GetGoodsReceivedNote)
+	protectedRouter.POST("/goods_received_notes/:id/void", can(models.PermPurchaseOrdersReceive), admin.VoidGoodsReceivedNote)
+	protectedRouter.GET("/purchase_orders/:id/approvals", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderApprovals)
+	protectedRouter.POST("/purchase_orders/:id/approvals/decide", can(models.PermPurchaseOrdersRead), admin.DecidePurchaseOrderApproval)
+	protectedRouter.GET("/purchase_order_approvals/pending", can(models.PermPurchaseOrdersRead), admin.GetPendingPurchaseOrderApprovals)
+
+	protectedRouter.GET("/purchase_order_approval_rules", can(models.PermPurchaseOrdersRead), admin.GetAllPurchaseOrderApprovalRules)
+	protectedRouter.POST("/purchase_order_approval_rules", can(models.PermPurchaseOrdersApprove), admin.CreatePurchaseOrderApprovalRule)
+	protectedRouter.PATCH("/purchase_order_approval_rules/:id", can(models.PermPurchaseOrdersApprove), admin.UpdatePurchaseOrderApprovalRule)
+	protectedRouter.DELETE("/purchase_order_approval_rules/:id", can(models.PermPurchaseOrdersApprove), admin.DeletePurchaseOrderApprovalRule)
+	protectedRouter.GET("/purchase_order_approval_rules/:id", can(models.PermPurchaseOrdersRead), admin.GetPurchaseOrderApprovalRule)
+
+	supplierRouter := r.Group("/api/v1/supplier")
+	supplierRouter.Use(middlewares.SupplierAuthMiddleware())
+
+	supplierRouter.GET("/profile", supplier.CurrentSupplier)
+	supplierRouter.POST("/logout", supplier.Logout)
+
+	supplierRouter.GET("/purchase_orders", supplier.GetAllPurchaseOrders)
+	supplierRouter.GET("/purchase_orders/:id", supplier.GetPurchaseOrder)
+	supplierRouter.GET("/purchase_orders/:id/pdf", supplier.GetPurchaseOrderPdf)
+	supplierRouter.POST("/purchase_orders/:id/acknowledge", supplier.AcknowledgePurchaseOrder)
+	supplierRouter.POST("/purchase_orders/:id/reject", supplier.RejectPurchaseOrder)
+}
@@ -6,19 +6,8 @@ import (
 )
 
 func SeedDatabase(db *gorm.DB) {
-    // Seed data
-    // users := []models.User{
-    //     {
-	// 		Username : "admin", 
-	// 		Name : "Admin",
-	// 		Email : "admin@example.com",
-	// 		Password : "secret",
-	// 	},
-    // }
-
-    // for _, user := range users {
-    //     db.Create(&user)
-    // }
+    // no user is seeded, the first owner is set up with the promote-owner
+    // command
 
 	// Create suppliers
     suppliers := []models.Supplier{
@@ -40,6 +29,16 @@ func SeedDatabase(db *gorm.DB) {
         db.Create(&productCategories[i])
     }
 
+	// Create warehouses
+    warehouses := []models.Warehouse{
+        {Name: "Central Kitchen", Code: "CK", Type: models.WarehouseTypeWarehouse, Address: "Yangon", IsActive: true},
+        {Name: "Outlet1", Code: "OL1", Type: models.WarehouseTypeOutlet, Address: "Yangon, Kamayut", IsActive: true},
+    }
+
+    for i := range warehouses {
+        db.Create(&warehouses[i])
+    }
+
 
 
 }
\ No newline at end of file
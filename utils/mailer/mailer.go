@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Mailer delivers a message, an error means it was not accepted and the
+// caller may try again later
+type Mailer interface {
+	Send(message Message) error
+}
+
+var ErrorNotConfigured = errors.New("mail is not configured, please set SMTP_HOST")
+
+// build writes the message as MIME, a plain text body followed by the
+// attachments in base64
+func build(from string, message Message) ([]byte, error) {
+
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	for _, to := range message.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("invalid recipient address %s", to)
+		}
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+
+	header := func(key, value string) {
+		buffer.WriteString(key + ": " + value + "\r\n")
+	}
+
+	header("From", from)
+	header("To", strings.Join(message.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", boundary))
+	buffer.WriteString("\r\n")
+
+	buffer.WriteString("--" + boundary + "\r\n")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buffer.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&buffer)
+	if _, err := body.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	buffer.WriteString("\r\n")
+
+	for _, attachment := range message.Attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		buffer.WriteString("--" + boundary + "\r\n")
+		header("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": attachment.Name}))
+		header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+		header("Content-Transfer-Encoding", "base64")
+		buffer.WriteString("\r\n")
+
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			buffer.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		buffer.WriteString(encoded + "\r\n")
+	}
+
+	buffer.WriteString("--" + boundary + "--\r\n")
+
+	return buffer.Bytes(), nil
+}
+
+func newBoundary() (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"time"
+)
+
+// SMTPMailer sends through an SMTP server. STARTTLS is used whenever the
+// server offers it, ImplicitTLS is for servers that expect TLS from the
+// first byte, usually on port 465. A local stand-in such as MailHog only
+// needs Host and Port
+type SMTPMailer struct {
+	Host        string
+	Port        string
+	Username    string
+	Password    string
+	From        string
+	ImplicitTLS bool
+	Timeout     time.Duration
+}
+
+// FromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD,
+// SMTP_FROM and SMTP_IMPLICIT_TLS
+func FromEnv() (*SMTPMailer, error) {
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, ErrorNotConfigured
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return &SMTPMailer{
+		Host:        host,
+		Port:        port,
+		Username:    os.Getenv("SMTP_USERNAME"),
+		Password:    os.Getenv("SMTP_PASSWORD"),
+		From:        os.Getenv("SMTP_FROM"),
+		ImplicitTLS: os.Getenv("SMTP_IMPLICIT_TLS") == "true",
+		Timeout:     30 * time.Second,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+
+	data, err := build(m.From, message)
+	if err != nil {
+		return err
+	}
+
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	address := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host}
+
+	var conn net.Conn
+	if m.ImplicitTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// one deadline for the whole conversation, a stuck server cannot
+	// hold the caller forever
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !m.ImplicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(envelopeAddress(m.From)); err != nil {
+		return err
+	}
+	for _, to := range message.To {
+		if err := client.Rcpt(envelopeAddress(to)); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// envelopeAddress strips the display name, "Shop <a@b.c>" gives a@b.c,
+// build has already checked the addresses parse
+func envelopeAddress(address string) string {
+
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.Address
+}
@@ -0,0 +1,228 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// signingKey is one entry of the keyset, PrivateKey is nil for retired
+// keys that are only kept to verify tokens issued before a rotation
+type signingKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{}
+	PublicKey  interface{}
+}
+
+// JWK is the public part of a key as published on the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var (
+	keySetOnce   sync.Once
+	keySetErr    error
+	activeKey    *signingKey
+	verifyingKey map[string]*signingKey
+)
+
+// LoadKeySet reads the signing keys once, main calls it at startup so a
+// broken keyset fails there instead of on the first login
+func LoadKeySet() error {
+
+	keySetOnce.Do(func() {
+		keySetErr = loadKeySet()
+	})
+	return keySetErr
+}
+
+// loadKeySet reads every <kid>.pem in JWT_KEYS_DIR and signs with
+// JWT_SIGNING_KEY_ID. API_SECRET stays valid for tokens without a kid, and
+// signs everything when no keys directory is configured
+func loadKeySet() error {
+
+	keys := map[string]*signingKey{}
+
+	var legacy *signingKey
+	if secret := os.Getenv("API_SECRET"); secret != "" {
+		legacy = &signingKey{Method: jwt.SigningMethodHS256, PrivateKey: []byte(secret), PublicKey: []byte(secret)}
+		keys[""] = legacy
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if legacy == nil {
+			return errors.New("API_SECRET or JWT_KEYS_DIR must be set")
+		}
+		activeKey = legacy
+		verifyingKey = keys
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		key, err := parseKeyFile(kid, data)
+		if err != nil {
+			return fmt.Errorf("jwt key %s: %v", kid, err)
+		}
+		keys[kid] = key
+	}
+
+	signingId := os.Getenv("JWT_SIGNING_KEY_ID")
+	signing, exists := keys[signingId]
+	if signingId == "" || !exists {
+		return fmt.Errorf("signing key %q not found in %s", signingId, dir)
+	}
+	if signing.PrivateKey == nil {
+		return fmt.Errorf("signing key %q has no private key", signingId)
+	}
+
+	activeKey = signing
+	verifyingKey = keys
+	return nil
+}
+
+func parseKeyFile(kid string, data []byte) (*signingKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, PrivateKey: k, PublicKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, PublicKey: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{ID: kid, Method: SigningMethodEd25519, PrivateKey: k, PublicKey: k.Public().(ed25519.PublicKey)}, nil
+	case ed25519.PublicKey:
+		return &signingKey{ID: kid, Method: SigningMethodEd25519, PublicKey: k}, nil
+	}
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+// signClaims signs with the active key and names it in the kid header
+func signClaims(claims jwt.MapClaims) (string, error) {
+
+	if err := LoadKeySet(); err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(activeKey.Method, claims)
+	if activeKey.ID != "" {
+		token.Header["kid"] = activeKey.ID
+	}
+
+	return token.SignedString(activeKey.PrivateKey)
+}
+
+// keyFunc picks the verification key by kid and refuses any other
+// algorithm than the one that key was loaded for
+func keyFunc(token *jwt.Token) (interface{}, error) {
+
+	if err := LoadKeySet(); err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	key, exists := verifyingKey[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS lists the public keys other services verify tokens with, shared
+// secrets are never published
+func JWKS() ([]JWK, error) {
+
+	if err := LoadKeySet(); err != nil {
+		return nil, err
+	}
+
+	results := []JWK{}
+
+	for _, key := range verifyingKey {
+		switch k := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			results = append(results, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			results = append(results, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(k),
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Kid < results[j].Kid
+	})
+
+	return results, nil
+}
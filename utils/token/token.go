@@ -1,6 +1,9 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,45 +14,198 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func GenerateToken(userid uint, username string) (string, error) {
+const (
+	ScopeUser      = "user"
+	ScopeSupplier  = "supplier"
+	ScopeChallenge = "2fa_challenge"
+)
+
+const ChallengeTokenLifespan = 5 * time.Minute
+
+const (
+	DefaultAccessTokenLifespan   = 15 * time.Minute
+	DefaultRefreshTokenLifespan  = 30 * 24 * time.Hour
+	DefaultSupplierTokenLifespan = 24 * time.Hour
+)
+
+// lifespan reads a whole number of units from the environment, fallback
+// applies when the variable is unset
+func lifespan(name string, unit time.Duration, fallback time.Duration) (time.Duration, error) {
+
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return unit * time.Duration(count), nil
+}
+
+type TokenClaims struct {
+	Scope      string
+	UserID     uint
+	SupplierID uint
+	Username   string
+	Role       string
+	DeviceID   string
+	DeviceName string
+	JTI        string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+func GenerateToken(userid uint, username string, role string, deviceId string) (string, error) {
 
-	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_HOUR_LIFESPAN"))
+	token_lifespan, err := lifespan("ACCESS_TOKEN_MINUTE_LIFESPAN", time.Minute, DefaultAccessTokenLifespan)
 
 	if err != nil {
 		return "", err
 	}
 
+	jti, err := generateJTI()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
+	claims["scope"] = ScopeUser
+	claims["jti"] = jti
 	claims["userid"] = userid
 	claims["username"] = username
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(token_lifespan)).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	claims["role"] = role
+	claims["device_id"] = deviceId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(token_lifespan).Unix()
+
+	return signClaims(claims)
+
+}
+
+// GenerateSupplierToken signs a token for the supplier portal, its scope
+// keeps it out of the staff routes
+func GenerateSupplierToken(supplierid uint, name string) (string, error) {
+
+	token_lifespan, err := lifespan("SUPPLIER_TOKEN_HOUR_LIFESPAN", time.Hour, DefaultSupplierTokenLifespan)
+
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := generateJTI()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	claims["authorized"] = true
+	claims["scope"] = ScopeSupplier
+	claims["jti"] = jti
+	claims["supplierid"] = supplierid
+	claims["username"] = name
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(token_lifespan).Unix()
 
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	return signClaims(claims)
 
 }
 
-var Blacklist = make(map[string]struct{})
+// GenerateChallengeToken is handed out instead of a session when the
+// password was right but a second factor is still needed
+func GenerateChallengeToken(userid uint, deviceId string, deviceName string) (string, error) {
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	claims["scope"] = ScopeChallenge
+	claims["userid"] = userid
+	claims["device_id"] = deviceId
+	claims["device_name"] = deviceName
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ChallengeTokenLifespan).Unix()
 
-func Logout(tokenString string) {
-	Blacklist[tokenString] = struct{}{}
+	return signClaims(claims)
+}
+
+func ParseChallengeToken(tokenString string) (*TokenClaims, error) {
+
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := toTokenClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	if result.Scope != ScopeChallenge {
+		return nil, fmt.Errorf("token is not a challenge token")
+	}
+	return result, nil
+}
+
+func generateJTI() (string, error) {
+
+	return randomHex(16)
+}
+
+func randomHex(size int) (string, error) {
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken returns an opaque refresh token and its expiry,
+// only the hash from HashToken is meant to be stored
+func GenerateRefreshToken() (string, time.Time, error) {
+
+	token_lifespan, err := lifespan("REFRESH_TOKEN_HOUR_LIFESPAN", time.Hour, DefaultRefreshTokenLifespan)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	refreshToken, err := GenerateRandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return refreshToken, time.Now().Add(token_lifespan), nil
+}
+
+// GenerateRandomToken returns an opaque single use token such as a
+// refresh or password reset token
+func GenerateRandomToken() (string, error) {
+
+	return randomHex(32)
+}
+
+func GenerateDeviceID() (string, error) {
+
+	return randomHex(16)
+}
+
+func HashToken(tokenString string) string {
+
+	sum := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(sum[:])
 }
 
 func TokenValid(c *gin.Context) error {
 	tokenString := ExtractToken(c)
 
-	if _, exists := Blacklist[tokenString]; exists {
-		return fmt.Errorf("token is blacklisted")
-	}
+	_, err := jwt.Parse(tokenString, keyFunc)
 
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
-	
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -69,27 +225,102 @@ func ExtractToken(c *gin.Context) string {
 	return ""
 }
 
-func ExtractTokenID(c *gin.Context) (uint, error) {
+func extractClaims(c *gin.Context) (jwt.MapClaims, error) {
 
-	tokenString := ExtractToken(c)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	return parseClaims(ExtractToken(c))
+}
+
+func parseClaims(tokenString string) (jwt.MapClaims, error) {
+
+	token, err := jwt.Parse(tokenString, keyFunc)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if ok && token.Valid {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("invalid token claims")
+}
+
+func ExtractTokenClaims(c *gin.Context) (*TokenClaims, error) {
+
+	claims, err := extractClaims(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTokenClaims(claims)
+}
+
+func toTokenClaims(claims jwt.MapClaims) (*TokenClaims, error) {
+
+	result := &TokenClaims{Scope: ScopeUser}
+	if scope, ok := claims["scope"].(string); ok {
+		result.Scope = scope
+	}
+
+	if result.Scope == ScopeSupplier {
+		sid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["supplierid"]), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		result.SupplierID = uint(sid)
+	} else {
 		uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["userid"]), 10, 32)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		return uint(uid), nil
+		result.UserID = uint(uid)
+	}
+
+	result.Username, _ = claims["username"].(string)
+	result.Role, _ = claims["role"].(string)
+	result.DeviceID, _ = claims["device_id"].(string)
+	result.DeviceName, _ = claims["device_name"].(string)
+	result.JTI, _ = claims["jti"].(string)
+	if iat, ok := claims["iat"].(float64); ok {
+		result.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		result.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+
+	return result, nil
+}
+
+func ExtractTokenID(c *gin.Context) (uint, error) {
+
+	claims, err := ExtractTokenClaims(c)
+	if err != nil {
+		return 0, err
 	}
-	return 0, nil
+	if claims.Scope != ScopeUser {
+		return 0, fmt.Errorf("token is not a user token")
+	}
+	return claims.UserID, nil
 }
 
+func ExtractTokenSupplierID(c *gin.Context) (uint, error) {
 
+	claims, err := ExtractTokenClaims(c)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Scope != ScopeSupplier {
+		return 0, fmt.Errorf("token is not a supplier token")
+	}
+	return claims.SupplierID, nil
+}
+
+func ExtractTokenRole(c *gin.Context) (string, error) {
+
+	claims, err := ExtractTokenClaims(c)
+	if err != nil {
+		return "", err
+	}
+	if claims.Role == "" {
+		return "", fmt.Errorf("token has no role")
+	}
+	return claims.Role, nil
+}